@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/window"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+func calibrateCmd(args []string) error {
+	if len(args) < 1 {
+		calibrateUsage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "capture":
+		return calibrateCaptureCmd(args[1:])
+	case "compute":
+		return calibrateComputeCmd(args[1:])
+	}
+	calibrateUsage()
+	os.Exit(2)
+	return nil
+}
+
+func calibrateUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s calibrate <capture|compute> [flags]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  capture  capture frames of a chessboard to calibrate the lens with\n")
+	fmt.Fprintf(os.Stderr, "  compute  compute the intrinsics of the lens from the frames and write them to a calibration file\n")
+}
+
+// registerChessboard registers the flags that describe the chessboard.
+func registerChessboard(fs *flag.FlagSet, b *detector.Chessboard) {
+	fs.IntVar(&b.Cols, "cols", 9, "inner corners of the chessboard along a row")
+	fs.IntVar(&b.Rows, "rows", 6, "inner corners of the chessboard along a column")
+	fs.Float64Var(&b.Square, "square", 1, "length of the squares of the chessboard, in any unit")
+}
+
+func calibrateCaptureCmd(args []string) error {
+	var (
+		vf    cameraFlags
+		board detector.Chessboard
+	)
+	fs := newFlagSet("calibrate capture", "[flags]", "Captures frames of a chessboard held in front of the camera at different positions\nand angles. Only the frames where the whole board is found are written, the\nintrinsics are computed from them with 'calibrate compute'.")
+	device := fs.Int("device", 0, "device ID for the camera")
+	dir := fs.String("dir", "calibration", "directory where the frames are written")
+	frames := fs.Int("frames", 20, "number of frames captured")
+	interval := fs.Duration("interval", 2*time.Second, "time between frames, to move the chessboard")
+	registerChessboard(fs, &board)
+	vf.register(fs)
+	fs.Parse(args)
+	if *frames < 1 {
+		return errors.New("at least one frame is needed")
+	}
+	if err := board.Validate(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return errors.Wrap(err, "Could not create the frames directory")
+	}
+
+	video, err := vf.open(*device, slog.Default())
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+	wm := window.New(800, 600)
+	defer wm.Close()
+
+	frame := gocv.NewMat()
+	defer frame.Close()
+	next := time.Now().Add(*interval)
+	for i := 0; i < *frames; {
+		if !video.Read(&frame) || frame.Empty() {
+			return errors.New("Could not read video frame")
+		}
+		wm.StreamFrame(frame)
+		if time.Now().Before(next) {
+			continue
+		}
+		// the frame is only kept if the whole board is in it.
+		if _, ok := board.Find(frame); !ok {
+			continue
+		}
+		name := filepath.Join(*dir, fmt.Sprintf("frame-%02d.png", i))
+		if !gocv.IMWrite(name, frame) {
+			return errors.Errorf("Could not write frame to %s", name)
+		}
+		fmt.Printf("%s %dx%d\n", name, frame.Cols(), frame.Rows())
+		next = time.Now().Add(*interval)
+		i++
+	}
+	return nil
+}
+
+func calibrateComputeCmd(args []string) error {
+	var board detector.Chessboard
+	fs := newFlagSet("calibrate compute", "[flags]", "Finds the chessboard in the frames written by 'calibrate capture', computes the\nfisheye intrinsics of the lens from them and writes them to the calibration file\nread by -lens.")
+	dir := fs.String("dir", "calibration", "directory where the frames are")
+	out := fs.String("o", "lens.json", "calibration file")
+	registerChessboard(fs, &board)
+	fs.Parse(args)
+	if err := board.Validate(); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(*dir, "*.png"))
+	if err != nil {
+		return errors.Wrap(err, "Could not list the frames")
+	}
+	var (
+		views []detector.Corners
+		size  image.Point
+	)
+	for _, name := range names {
+		img := gocv.IMRead(name, gocv.IMReadGrayScale)
+		if img.Empty() {
+			img.Close()
+			return errors.Errorf("Could not read frame %s", name)
+		}
+		s := image.Pt(img.Cols(), img.Rows())
+		corners, ok := board.Find(img)
+		img.Close()
+		if size == (image.Point{}) {
+			size = s
+		}
+		if s != size {
+			return errors.Errorf("frame %s is %dx%d, the others are %dx%d", name, s.X, s.Y, size.X, size.Y)
+		}
+		if !ok {
+			fmt.Printf("%s: chessboard not found, skipped\n", name)
+			continue
+		}
+		views = append(views, corners)
+	}
+	lens, rms, err := board.Calibrate(views, size)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("calibrated with %d frames of %dx%d, RMS reprojection error %.3f pixels\n", len(views), size.X, size.Y, rms)
+	fmt.Printf("fx=%.2f fy=%.2f cx=%.2f cy=%.2f d=%.4f,%.4f,%.4f,%.4f\n", lens.FX, lens.FY, lens.CX, lens.CY, lens.D[0], lens.D[1], lens.D[2], lens.D[3])
+	return lens.Save(*out)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/matipan/dartagnan/hud"
+	"github.com/matipan/dartagnan/target"
+	"github.com/matipan/dartagnan/trigger"
+)
+
+// controlHandler serves the endpoints that change how dartagnan
+// behaves while it runs. tr is nil if there is no trigger.
+//
+//	GET  /control/policy                           returns the target policy
+//	POST /control/policy?name=<name>               changes the target policy
+//	POST /control/arm                              arms the trigger
+//	POST /control/disarm                           disarms the trigger
+//	GET  /control/hud                              returns the HUD elements that are on
+//	POST /control/hud?element=<element>&on=<bool>  turns a HUD element on or off
+func controlHandler(sel *target.Selector, tr *trigger.Trigger, h *hud.HUD) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/control/hud", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			e, err := hud.ParseElement(r.FormValue("element"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			on, err := strconv.ParseBool(r.FormValue("on"))
+			if err != nil {
+				http.Error(w, "on must be true or false", http.StatusBadRequest)
+				return
+			}
+			h.Toggle(e, on)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		for _, e := range h.Enabled() {
+			fmt.Fprintln(w, e)
+		}
+	})
+	mux.HandleFunc("/control/policy", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintln(w, sel.Policy())
+		case http.MethodPost:
+			if err := sel.SetPolicy(r.FormValue("name")); err != nil {
+				http.Error(w, fmt.Sprintf("%v, want one of %s", err, strings.Join(target.PolicyNames(), ", ")), http.StatusBadRequest)
+				return
+			}
+			fmt.Fprintln(w, sel.Policy())
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	trigger := func(change func() error) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if tr == nil {
+				http.Error(w, "the trigger is disabled", http.StatusNotFound)
+				return
+			}
+			if err := change(); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			fmt.Fprintln(w, tr.State())
+		}
+	}
+	mux.Handle("/control/arm", trigger(func() error { return tr.Arm() }))
+	mux.Handle("/control/disarm", trigger(func() error { return tr.Disarm() }))
+	return mux
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/event"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+func detectCmd(args []string) error {
+	fs := newFlagSet("detect", "[flags] <file>", "Runs motion detection on a video file and prints one line per detection:\nframe, the bounding rectangle and its middle point.")
+	area := fs.Float64("area", minArea, "base area for motion detection")
+	var df detectorFlags
+	df.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a video file is required")
+	}
+
+	video, err := gocv.VideoCaptureFile(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "Could not open video file")
+	}
+	d, err := detector.New(video, *area, func(d event.Detection) {
+		fmt.Printf("frame=%d rect=%v middle=%v area=%.0f confidence=%.2f velocity=(%.0f,%.0f)\n", d.Frame.Seq, d.Rect, d.Middle(), d.Area, d.Confidence, d.Velocity.X, d.Velocity.Y)
+	}, nopStreamer{}, nil)
+	if err != nil {
+		return err
+	}
+	if err := df.apply(d); err != nil {
+		d.Close()
+		return err
+	}
+	return ignoreEOF(d.Run(context.Background()))
+}
+
+// ignoreEOF ignores the error returned by the detector when
+// the end of a video file is reached.
+func ignoreEOF(err error) error {
+	if errors.Cause(err) == detector.ErrReadFrame {
+		return nil
+	}
+	return err
+}
+
+// nopStreamer discards every image.
+type nopStreamer struct{}
+
+func (nopStreamer) StreamDelta(img gocv.Mat)  {}
+func (nopStreamer) StreamFrame(img gocv.Mat)  {}
+func (nopStreamer) StreamThresh(img gocv.Mat) {}
@@ -0,0 +1,79 @@
+package detector
+
+import (
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// manualExposure is the value of the auto exposure property that
+// turns it off in the V4L2 backend of OpenCV.
+const manualExposure = 0.25
+
+// videoCaptureAutoWB toggles the automatic white balance. It is
+// not one of the properties defined by gocv.
+const videoCaptureAutoWB gocv.VideoCaptureProperties = 44
+
+// CameraConfig are the settings of a camera. Zero values keep the
+// default of the driver.
+type CameraConfig struct {
+	// Width and Height are the resolution of the frames.
+	Width, Height int
+	// FPS is the rate at which frames are captured.
+	FPS float64
+	// Exposure is a fixed exposure, in the units of the driver.
+	// Automatic exposure changes the brightness of the whole frame
+	// and is detected as motion.
+	Exposure float64
+	// WhiteBalance is a fixed white balance temperature in Kelvin,
+	// it disables the automatic white balance.
+	WhiteBalance float64
+}
+
+// CameraProperty is a property of the camera set by Configure.
+type CameraProperty struct {
+	Name string
+	// Requested is the value that was set and Actual the value
+	// read back from the driver, which may round it or ignore it.
+	Requested, Actual float64
+}
+
+// propertyTolerance is the relative difference between the requested
+// and the actual value of a property that is still accepted, drivers
+// report 30 fps as 29.97 for example.
+const propertyTolerance = 0.01
+
+// Accepted returns whether the driver accepted the value, allowing
+// for the rounding of the driver.
+func (p CameraProperty) Accepted() bool {
+	return math.Abs(p.Requested-p.Actual) <= propertyTolerance*math.Max(math.Abs(p.Requested), 1)
+}
+
+// Configure sets the properties of the camera described by cfg and
+// returns the value the driver took for each of them.
+func Configure(video *gocv.VideoCapture, cfg CameraConfig) []CameraProperty {
+	var props []CameraProperty
+	set := func(name string, prop gocv.VideoCaptureProperties, value float64) {
+		video.Set(prop, value)
+		props = append(props, CameraProperty{Name: name, Requested: value, Actual: video.Get(prop)})
+	}
+	if cfg.Width > 0 {
+		set("width", gocv.VideoCaptureFrameWidth, float64(cfg.Width))
+	}
+	if cfg.Height > 0 {
+		set("height", gocv.VideoCaptureFrameHeight, float64(cfg.Height))
+	}
+	if cfg.FPS > 0 {
+		set("fps", gocv.VideoCaptureFPS, cfg.FPS)
+	}
+	if cfg.Exposure > 0 {
+		// the exposure is ignored unless the automatic one is off.
+		set("auto_exposure", gocv.VideoCaptureAutoExposure, manualExposure)
+		set("exposure", gocv.VideoCaptureExposure, cfg.Exposure)
+	}
+	if cfg.WhiteBalance > 0 {
+		set("auto_white_balance", videoCaptureAutoWB, 0)
+		set("white_balance", gocv.VideoCaptureTemperature, cfg.WhiteBalance)
+	}
+	return props
+}
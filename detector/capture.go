@@ -0,0 +1,222 @@
+package detector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// CapturePolicy is what a capture does when frames are read faster
+// than they are processed.
+type CapturePolicy int
+
+const (
+	// Latest keeps only the newest frame and drops the others, so
+	// the detector never processes old frames.
+	Latest CapturePolicy = iota
+	// All waits for each frame to be processed before reading the
+	// next one, for video files that must be processed entirely.
+	All
+)
+
+// ParseCapturePolicy returns the policy with the given name,
+// latest or all.
+func ParseCapturePolicy(name string) (CapturePolicy, error) {
+	switch name {
+	case "latest":
+		return Latest, nil
+	case "all":
+		return All, nil
+	}
+	return 0, errors.Errorf("unknown capture policy %q", name)
+}
+
+// StampedSource is a frame source that knows the sequence number
+// and capture time of the frames it reads.
+type StampedSource interface {
+	FrameSource
+	// Stamp returns the frame returned by the last call to Read.
+	Stamp() event.Frame
+}
+
+// Waker tells whether the pipeline runs at full rate or sleeps.
+// *wake.Wake implements it.
+type Waker interface {
+	Awake() bool
+}
+
+// Capture reads frames from a source on its own goroutine, so
+// reading from a camera never waits for the processing of the
+// previous frame. It implements StampedSource.
+type Capture struct {
+	src    FrameSource
+	policy CapturePolicy
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// latest is the newest frame read and fresh whether it was
+	// not returned by Read yet.
+	latest      gocv.Mat
+	latestStamp event.Frame
+	fresh       bool
+	// stamp is the frame last returned by Read.
+	stamp event.Frame
+	// failed is true once the source fails to read a frame and
+	// closed once the capture is closed.
+	failed  bool
+	closed  bool
+	dropped uint64
+	// waker is nil if the capture never sleeps and waiting is the
+	// number of calls to Read waiting for a frame.
+	waker   Waker
+	waiting int
+
+	done chan struct{}
+}
+
+// NewCapture starts reading frames from src with the policy.
+func NewCapture(src FrameSource, policy CapturePolicy) *Capture {
+	c := &Capture{
+		src:    src,
+		policy: policy,
+		latest: gocv.NewMat(),
+		done:   make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.run()
+	return c
+}
+
+func (c *Capture) run() {
+	defer close(c.done)
+	frame := gocv.NewMat()
+	defer frame.Close()
+	var seq uint64
+	for {
+		if !c.wait() {
+			return
+		}
+		ok := c.src.Read(&frame)
+		seq++
+		now := time.Now()
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		if !ok {
+			c.failed = true
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			return
+		}
+		metrics.FramesCaptured.Inc()
+		metrics.CaptureFPS.Mark()
+		for c.policy == All && c.fresh && !c.closed {
+			c.cond.Wait()
+		}
+		if c.fresh {
+			c.dropped++
+			metrics.FramesDropped.Inc()
+		}
+		// the frames are swapped so the next one is read into
+		// the memory of the old one.
+		c.latest, frame = frame, c.latest
+		c.latestStamp = event.Frame{Seq: seq, CapturedAt: now}
+		c.fresh = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// wait waits while the pipeline sleeps until Read asks for a frame,
+// so the source is not read faster than frames are processed. It
+// returns false if the capture was closed.
+func (c *Capture) wait() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.closed && c.waker != nil && c.waiting == 0 && !c.waker.Awake() {
+		// nobody waits for the frame, it would be old once
+		// it is read.
+		if c.fresh {
+			c.fresh = false
+			c.dropped++
+			metrics.FramesDropped.Inc()
+		}
+		c.cond.Wait()
+	}
+	return !c.closed
+}
+
+// SetWaker makes the capture read frames only when Read asks for
+// them while the pipeline sleeps, instead of at the rate of the
+// source.
+func (c *Capture) SetWaker(w Waker) {
+	c.mu.Lock()
+	c.waker = w
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// Read implements the FrameSource interface. It waits for a frame
+// newer than the last one it returned. It returns false once the
+// source fails and every frame read before was returned.
+func (c *Capture) Read(m *gocv.Mat) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waiting++
+	c.cond.Broadcast()
+	for !c.fresh && !c.failed && !c.closed {
+		c.cond.Wait()
+	}
+	c.waiting--
+	if !c.fresh {
+		return false
+	}
+	*m, c.latest = c.latest, *m
+	c.stamp = c.latestStamp
+	c.fresh = false
+	c.cond.Broadcast()
+	return true
+}
+
+// Stamp implements the StampedSource interface.
+func (c *Capture) Stamp() event.Frame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stamp
+}
+
+// Dropped returns the number of frames that were read but never
+// returned by Read.
+func (c *Capture) Dropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped
+}
+
+// Close stops reading and closes the source. It waits for the read
+// in progress to finish, so the source is never closed while it is
+// being read.
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	<-c.done
+
+	var result *multierror.Error
+	if err := c.src.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := c.latest.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,79 @@
+#include <cstring>
+
+#include "chessboard.h"
+
+// Chessboard_Find finds the cols x rows inner corners of a chessboard in
+// img and refines them to subpixel accuracy. The corners are written to
+// corners as x, y pairs row by row and their number is returned, 0 if
+// the board was not found.
+int Chessboard_Find(void* img, int cols, int rows, float* corners) {
+    cv::Mat* src = static_cast<cv::Mat*>(img);
+    cv::Mat gray;
+    if (src->channels() == 1) {
+        gray = *src;
+    } else {
+        cv::cvtColor(*src, gray, cv::COLOR_BGR2GRAY);
+    }
+
+    std::vector<cv::Point2f> found;
+    int flags = cv::CALIB_CB_ADAPTIVE_THRESH | cv::CALIB_CB_NORMALIZE_IMAGE | cv::CALIB_CB_FAST_CHECK;
+    if (!cv::findChessboardCorners(gray, cv::Size(cols, rows), found, flags)) {
+        return 0;
+    }
+    cv::cornerSubPix(gray, found, cv::Size(11, 11), cv::Size(-1, -1),
+                     cv::TermCriteria(cv::TermCriteria::EPS + cv::TermCriteria::COUNT, 30, 0.01));
+
+    for (size_t i = 0; i < found.size(); i++) {
+        corners[2 * i] = found[i].x;
+        corners[2 * i + 1] = found[i].y;
+    }
+    return found.size();
+}
+
+// Chessboard_Calibrate computes the fisheye intrinsics of the lens from
+// the corners found in views frames of width x height, cols * rows x, y
+// pairs for each view. The focal lengths and the principal point are
+// written to k as fx, fy, cx, cy and the distortion coefficients to d.
+// It returns the RMS reprojection error, or -1 with the reason in err if
+// the calibration failed.
+double Chessboard_Calibrate(const float* corners, int views, int cols, int rows, double square,
+                            int width, int height, double* k, double* d, char* err, int errLen) {
+    std::vector<cv::Point3d> board;
+    for (int y = 0; y < rows; y++) {
+        for (int x = 0; x < cols; x++) {
+            board.push_back(cv::Point3d(x * square, y * square, 0));
+        }
+    }
+    std::vector<std::vector<cv::Point3d> > objectPoints(views, board);
+
+    int n = cols * rows;
+    std::vector<std::vector<cv::Point2d> > imagePoints(views);
+    for (int v = 0; v < views; v++) {
+        for (int i = 0; i < n; i++) {
+            const float* c = &corners[2 * (v * n + i)];
+            imagePoints[v].push_back(cv::Point2d(c[0], c[1]));
+        }
+    }
+
+    cv::Matx33d K;
+    cv::Vec4d D;
+    std::vector<cv::Vec3d> rvecs, tvecs;
+    int flags = cv::fisheye::CALIB_RECOMPUTE_EXTRINSIC | cv::fisheye::CALIB_FIX_SKEW;
+    try {
+        double rms = cv::fisheye::calibrate(objectPoints, imagePoints, cv::Size(width, height), K, D,
+                                            rvecs, tvecs, flags,
+                                            cv::TermCriteria(cv::TermCriteria::EPS + cv::TermCriteria::COUNT, 100, 1e-6));
+        k[0] = K(0, 0);
+        k[1] = K(1, 1);
+        k[2] = K(0, 2);
+        k[3] = K(1, 2);
+        for (int i = 0; i < 4; i++) {
+            d[i] = D[i];
+        }
+        return rms;
+    } catch (const cv::Exception& e) {
+        strncpy(err, e.what(), errLen - 1);
+        err[errLen - 1] = '\0';
+        return -1;
+    }
+}
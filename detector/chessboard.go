@@ -0,0 +1,96 @@
+package detector
+
+/*
+#cgo !windows pkg-config: opencv
+#cgo CXXFLAGS:   --std=c++11
+#include "chessboard.h"
+*/
+import "C"
+import (
+	"image"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// Chessboard is the pattern the lens is calibrated with: a chessboard
+// with Cols x Rows inner corners whose squares are Square long. The
+// intrinsics do not depend on the length of the squares.
+type Chessboard struct {
+	Cols, Rows int
+	Square     float64
+}
+
+// Corners are the inner corners of a chessboard found in a frame, in
+// pixels and row by row.
+type Corners [][2]float32
+
+// minViews is the number of views of the chessboard needed to
+// calibrate the lens.
+const minViews = 3
+
+// Validate checks that the chessboard has inner corners.
+func (b Chessboard) Validate() error {
+	if b.Cols < 2 || b.Rows < 2 {
+		return errors.Errorf("a chessboard needs at least 2x2 inner corners, got %dx%d", b.Cols, b.Rows)
+	}
+	if b.Square <= 0 {
+		return errors.New("the squares of the chessboard must be longer than zero")
+	}
+	return nil
+}
+
+// Find finds the inner corners of the chessboard in img, refined to
+// subpixel accuracy. It returns false if the whole board is not in img.
+func (b Chessboard) Find(img gocv.Mat) (Corners, bool) {
+	n := b.Cols * b.Rows
+	buf := make([]C.float, 2*n)
+	found := int(C.Chessboard_Find(unsafe.Pointer(img.Ptr()), C.int(b.Cols), C.int(b.Rows), &buf[0]))
+	if found != n {
+		return nil, false
+	}
+	cs := make(Corners, n)
+	for i := range cs {
+		cs[i] = [2]float32{float32(buf[2*i]), float32(buf[2*i+1])}
+	}
+	return cs, true
+}
+
+// Calibrate computes the fisheye intrinsics of the lens from the
+// corners found in views of the chessboard in frames of the given
+// size. It returns the lens and the RMS reprojection error in pixels.
+func (b Chessboard) Calibrate(views []Corners, size image.Point) (Lens, float64, error) {
+	if len(views) < minViews {
+		return Lens{}, 0, errors.Errorf("at least %d views of the chessboard are needed, got %d", minViews, len(views))
+	}
+	n := b.Cols * b.Rows
+	buf := make([]C.float, 0, 2*n*len(views))
+	for i, v := range views {
+		if len(v) != n {
+			return Lens{}, 0, errors.Errorf("view %d has %d corners, want %d", i, len(v), n)
+		}
+		for _, c := range v {
+			buf = append(buf, C.float(c[0]), C.float(c[1]))
+		}
+	}
+	var k, d [4]C.double
+	reason := make([]C.char, 256)
+	rms := float64(C.Chessboard_Calibrate(&buf[0], C.int(len(views)), C.int(b.Cols), C.int(b.Rows), C.double(b.Square),
+		C.int(size.X), C.int(size.Y), &k[0], &d[0], &reason[0], C.int(len(reason))))
+	if rms < 0 {
+		return Lens{}, 0, errors.Errorf("Could not calibrate the lens: %s", C.GoString(&reason[0]))
+	}
+	l := Lens{
+		Width:  size.X,
+		Height: size.Y,
+		FX:     float64(k[0]),
+		FY:     float64(k[1]),
+		CX:     float64(k[2]),
+		CY:     float64(k[3]),
+	}
+	for i := range l.D {
+		l.D[i] = float64(d[i])
+	}
+	return l, rms, l.Validate()
+}
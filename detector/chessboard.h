@@ -0,0 +1,21 @@
+#ifndef _DARTAGNAN_CHESSBOARD_H_
+#define _DARTAGNAN_CHESSBOARD_H_
+
+#ifdef __cplusplus
+#include <opencv2/opencv.hpp>
+#include <opencv2/calib3d.hpp>
+
+extern "C" {
+#endif
+
+// img is the cv::Mat* of a gocv.Mat, it is passed as a void* because
+// the Mat type of gocv is not visible outside its package.
+int Chessboard_Find(void* img, int cols, int rows, float* corners);
+double Chessboard_Calibrate(const float* corners, int views, int cols, int rows, double square,
+                            int width, int height, double* k, double* d, char* err, int errLen);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif //_DARTAGNAN_CHESSBOARD_H_
@@ -3,21 +3,22 @@ package detector
 import (
 	"context"
 	"image"
-	"image/color"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/go-multierror"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
 	"github.com/pkg/errors"
 	"gocv.io/x/gocv"
 )
 
-var (
-	rectColor   = color.RGBA{R: 0, G: 255, B: 0, A: 0}
-	textColor   = color.RGBA{R: 0, G: 0, B: 255, A: 0}
-	statusPoint = image.Pt(10, 20)
-)
-
-// Detector detects objects reading from a video device.
+// Detector detects objects reading from a video source.
 type Detector struct {
-	video *gocv.VideoCapture
+	video FrameSource
 
 	firstFrame gocv.Mat
 	frame      gocv.Mat
@@ -25,39 +26,170 @@ type Detector struct {
 	delta      gocv.Mat
 	thresh     gocv.Mat
 	kernel     gocv.Mat
+	// annotated is a copy of frame where the overlays draw, so
+	// the frame itself is streamed raw.
+	annotated gocv.Mat
+	// info is what was found in the last frame.
+	info Info
 
 	handler HandleMotion
 
 	streamer Streamer
+	overlays []Overlay
+	laser    *Laser
+	ego      *egoMotion
+	flow     *flow
+	selector Selector
+	lens     *undistorter
+	// rebase is true when the next frame must be taken as the
+	// background.
+	rebase bool
 
 	area float64
+
+	// lastFrame is the time, in unix nanoseconds, at which
+	// the last frame was read.
+	lastFrame int64
+	// seq is the number of frames read.
+	seq uint64
+	// throttle paces the frames that are processed and paused is 1
+	// while the detector waits for it.
+	throttle Throttle
+	paused   int32
+
+	log   *slog.Logger
+	debug *slog.Logger
+}
+
+// FrameSource is a source of video frames such as a camera
+// device or a video file. *gocv.VideoCapture implements it.
+type FrameSource interface {
+	Read(m *gocv.Mat) bool
+	Close() error
+}
+
+// Throttle paces the frames processed by the detector, for example
+// to process few of them while nothing happens. Wait blocks until
+// the next frame should be processed or the context is closed.
+type Throttle interface {
+	Wait(ctx context.Context) error
 }
 
-// Streamer holds stream methods for each type of image.
+// Streamer holds stream methods for each type of image. The
+// frames are streamed with the overlays drawn on them.
 type Streamer interface {
 	StreamDelta(img gocv.Mat)
 	StreamFrame(img gocv.Mat)
 	StreamThresh(img gocv.Mat)
 }
 
+// RawStreamer is a streamer that also wants the frames without
+// the overlays, such as a recorder.
+type RawStreamer interface {
+	Streamer
+	StreamRaw(img gocv.Mat)
+}
+
+// Streamers returns a streamer that streams to each of the
+// streamers in order.
+func Streamers(streamers ...Streamer) Streamer {
+	return multiStreamer(streamers)
+}
+
+type multiStreamer []Streamer
+
+func (m multiStreamer) StreamDelta(img gocv.Mat) {
+	for _, s := range m {
+		s.StreamDelta(img)
+	}
+}
+
+func (m multiStreamer) StreamFrame(img gocv.Mat) {
+	for _, s := range m {
+		s.StreamFrame(img)
+	}
+}
+
+func (m multiStreamer) StreamThresh(img gocv.Mat) {
+	for _, s := range m {
+		s.StreamThresh(img)
+	}
+}
+
+func (m multiStreamer) StreamRaw(img gocv.Mat) {
+	for _, s := range m {
+		if raw, ok := s.(RawStreamer); ok {
+			raw.StreamRaw(img)
+		}
+	}
+}
+
+// Overlay draws on top of a copy of each frame before it is
+// streamed. info is what the detector found in the frame.
+type Overlay func(img *gocv.Mat, info Info)
+
+// Info is what the detector found in a frame.
+type Info struct {
+	// Frame is the frame.
+	Frame event.Frame
+	// Latency is the time it took to process the frame, from
+	// reading it to handling the detection.
+	Latency time.Duration
+	// FPS is the rate at which frames are processed.
+	FPS float64
+	// Detections are every object in motion found in the frame
+	// and Target, if TargetFound is true, the one that was sent
+	// to the handler.
+	Detections  []event.Detection
+	Target      event.Detection
+	TargetFound bool
+	// Dot is where the laser dot was found, if DotFound is true.
+	Dot      image.Point
+	DotFound bool
+	// Suppressed is true if detection was suppressed because
+	// the camera was moving.
+	Suppressed bool
+}
+
+// Selector follows the objects in motion across frames and chooses
+// the one the turrets engage. *target.Selector implements it.
+type Selector interface {
+	// Select is called with every object in motion found in a
+	// frame captured at the given time.
+	Select(ds []event.Detection, at time.Time) (event.Detection, bool)
+}
+
 // HandleMotion is the function that gets called when motion
 // is detected.
-type HandleMotion func(rect image.Rectangle)
+type HandleMotion func(d event.Detection)
+
+// Handlers returns a HandleMotion that calls each of the
+// handlers in order. It allows driving several turrets from
+// the same detector.
+func Handlers(handlers ...HandleMotion) HandleMotion {
+	return func(d event.Detection) {
+		for _, h := range handlers {
+			h(d)
+		}
+	}
+}
 
-// New creates a new detector, it opens the device specified by `deviceID`.
+// New creates a new detector that reads frames from `video`. The first
+// frame read is used as the background.
 // The minimum size of the area in motion will be specified by `area`.
 // Each type of image will be streamed to the streamer.
 // `handler` will be called when motion is detected.
-func New(deviceID int, area float64, handler HandleMotion, streamer Streamer) (*Detector, error) {
-	video, err := gocv.VideoCaptureDevice(deviceID)
-	if err != nil {
-		return nil, errors.Wrap(err, "Could not open capture device")
+// Logs are written to logger, if it is nil they are discarded.
+func New(video FrameSource, area float64, handler HandleMotion, streamer Streamer, logger *slog.Logger) (*Detector, error) {
+	if logger == nil {
+		logger = logging.Discard()
 	}
-
 	frame := gocv.NewMat()
 	firstFrame := gocv.NewMat()
 	if !video.Read(&frame) {
-		return nil, errors.Wrap(err, "Could not read first video frame")
+		frame.Close()
+		firstFrame.Close()
+		return nil, errors.New("Could not read first video frame")
 	}
 	convertFrame(frame, &firstFrame)
 	gocv.Flip(firstFrame, &firstFrame, 1)
@@ -70,84 +202,386 @@ func New(deviceID int, area float64, handler HandleMotion, streamer Streamer) (*
 		delta:      gocv.NewMat(),
 		thresh:     gocv.NewMat(),
 		kernel:     gocv.NewMat(),
+		annotated:  gocv.NewMat(),
 		streamer:   streamer,
 		handler:    handler,
 		area:       area,
+		log:        logger,
+		debug:      logging.RateLimited(logger, time.Second),
+		lastFrame:  time.Now().UnixNano(),
+		seq:        1,
 	}, nil
 }
 
-// Run runs the detector until the context is closed.
-func (d *Detector) Run(ctx context.Context) {
-	defer d.close()
+// ErrReadFrame is returned by Run when a frame could not be read
+// from the source. For video files it means the end of the file
+// was reached.
+var ErrReadFrame = errors.New("Could not read video frame")
+
+// Run runs the detector until the context is closed or an error
+// happens. The detector is closed once Run returns, the returned
+// error is nil only if the context was closed.
+func (d *Detector) Run(ctx context.Context) (err error) {
+	defer func() {
+		if cerr := d.close(); err == nil && cerr != nil {
+			err = errors.Wrap(cerr, "Could not close detector")
+		}
+	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
-			if d.scan() {
-				return
+			if d.throttle != nil && !d.wait(ctx) {
+				return nil
+			}
+			if err := d.scan(); err != nil {
+				return err
 			}
 		}
 	}
 }
 
+// wait waits for the throttle. It returns false if the context was
+// closed meanwhile.
+func (d *Detector) wait(ctx context.Context) bool {
+	atomic.StoreInt32(&d.paused, 1)
+	err := d.throttle.Wait(ctx)
+	atomic.StoreInt32(&d.paused, 0)
+	// a stalled source is detected from when the detector resumed.
+	atomic.StoreInt64(&d.lastFrame, time.Now().UnixNano())
+	return err == nil
+}
+
+// SetThrottle makes the detector wait for the throttle before
+// reading each frame.
+func (d *Detector) SetThrottle(t Throttle) {
+	d.throttle = t
+}
+
+// Paused returns whether the detector is waiting for its throttle,
+// frames are not read meanwhile. It is safe to call it while the
+// detector runs.
+func (d *Detector) Paused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+// AddOverlay adds an overlay that is drawn on a copy of each
+// frame before it is streamed.
+func (d *Detector) AddOverlay(o Overlay) {
+	d.overlays = append(d.overlays, o)
+}
+
+// SetLaser makes the detector look for the dot of a laser pointer
+// in each frame. The dot is sent with the detections so the aim can
+// be corrected. The laser is closed with the detector.
+func (d *Detector) SetLaser(l *Laser) {
+	d.laser = l
+}
+
+// SetSelector makes the selector choose which of the objects in
+// motion is sent to the handler. By default it is the biggest one.
+func (d *Detector) SetSelector(s Selector) {
+	d.selector = s
+}
+
+// UseFlow makes the detector find motion with the dense optical
+// flow between consecutive frames instead of differencing each
+// frame against the background. It measures the velocity of the
+// detections and ignores the regions slower than minSpeed, in
+// pixels per second, such as shadows or changes of the light.
+func (d *Detector) UseFlow(minSpeed float64) {
+	d.flow = newFlow(minSpeed)
+	d.flow.restart(d.firstFrame, time.Now())
+}
+
+// SetMount tells the detector that the camera is mounted on the
+// turret m. Detection is suppressed while the servos move and for
+// settle after each command, the background is taken again once
+// they stop and the remaining motion of the camera is compensated
+// before comparing each frame with the background.
+func (d *Detector) SetMount(m Mount, settle time.Duration) {
+	d.ego = newEgoMotion(m, settle)
+}
+
+// SetLens removes the distortion of the lens from every frame
+// before looking for motion, so the pixels of the detections map
+// to the right angles. The background is taken again from the
+// next frame.
+func (d *Detector) SetLens(l Lens) {
+	if d.lens != nil {
+		d.lens.close()
+	}
+	d.lens = newUndistorter(l)
+	d.rebase = true
+}
+
+// LastFrame returns the time at which the last frame was read
+// from the source. It is safe to call it while the detector runs
+// and can be used to detect a stalled source.
+func (d *Detector) LastFrame() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&d.lastFrame))
+}
+
 // scan scans the video for a new frame. It then parses this
 // frame applying a few filters, thresholds and dilations in
 // order to then calculate the contour of the area in movement.
 // Once it has the contour it will draw the bounding rectangle
 // and call the handle motion function.
-func (d *Detector) scan() bool {
+func (d *Detector) scan() error {
+	start := time.Now()
 	if !d.video.Read(&d.frame) {
-		return true
+		metrics.Errors.With("camera").Inc()
+		return ErrReadFrame
+	}
+	readAt := time.Now()
+	atomic.StoreInt64(&d.lastFrame, readAt.UnixNano())
+	d.seq++
+	frame := event.Frame{Seq: d.seq, CapturedAt: readAt}
+	if src, ok := d.video.(StampedSource); ok {
+		// the source reads frames on its own, they may have
+		// been captured before the previous one was processed.
+		frame = src.Stamp()
+		readAt = frame.CapturedAt
+	} else {
+		metrics.CaptureFPS.Mark()
+		metrics.FramesCaptured.Inc()
+	}
+	metrics.ProcessedFPS.Mark()
+	d.info = Info{Frame: frame}
+	var (
+		camera event.Angles
+		moving bool
+	)
+	if d.ego != nil {
+		x, y := d.ego.mount.Position()
+		camera = event.Angles{X: x, Y: y}
+		moving = time.Since(d.ego.mount.LastMove()) < d.ego.settle
+	}
+	if d.frame.Empty() {
+		metrics.FramesDropped.Inc()
+		return nil
+	}
+	start = observe("read", start)
+
+	if d.lens != nil {
+		d.lens.undistort(&d.frame)
 	}
 	gocv.Flip(d.frame, &d.frame, 1)
 	convertFrame(d.frame, &d.gray)
+	start = observe("preprocess", start)
+	if d.rebase {
+		d.rebase = false
+		d.gray.CopyTo(&d.firstFrame)
+		if d.flow != nil {
+			d.flow.restart(d.gray, readAt)
+		}
+		return d.stream(start)
+	}
 
-	gocv.AbsDiff(d.firstFrame, d.gray, &d.delta)
-	gocv.Threshold(d.delta, &d.thresh, 50, 255, gocv.ThresholdBinary)
-	gocv.Dilate(d.thresh, &d.thresh, d.kernel)
-	cnt := bestContour(d.thresh.Clone(), d.area)
-	if len(cnt) > 0 {
-		rect := gocv.BoundingRect(cnt)
-		gocv.Rectangle(&d.frame, rect, rectColor, 2)
-		gocv.PutText(&d.frame, "Motion detected", statusPoint, gocv.FontHersheyPlain, 1.2, textColor, 2)
-		d.handler(rect)
+	var (
+		bg    = d.firstFrame
+		shift image.Point
+	)
+	if d.ego != nil {
+		switch {
+		case moving:
+			d.ego.moving = true
+			d.info.Suppressed = true
+			metrics.FramesSuppressed.Inc()
+			return d.stream(start)
+		case d.ego.moving:
+			// the turret stopped, what the camera sees now is
+			// the new background.
+			d.ego.moving = false
+			d.gray.CopyTo(&d.firstFrame)
+			if d.flow != nil {
+				d.flow.restart(d.gray, readAt)
+			}
+			return d.stream(start)
+		case d.flow != nil:
+			shift, _ = d.ego.estimate(d.flow.prev, d.gray)
+		default:
+			bg = d.ego.compensate(d.firstFrame, d.gray)
+		}
+		start = observe("ego_motion", start)
+	}
+
+	if d.flow != nil {
+		d.flow.motion(d.gray, readAt, shift, &d.delta, &d.thresh)
+		gocv.Dilate(d.thresh, &d.thresh, d.kernel)
+		start = observe("flow", start)
+	} else {
+		gocv.AbsDiff(bg, d.gray, &d.delta)
+		gocv.Threshold(d.delta, &d.thresh, 50, 255, gocv.ThresholdBinary)
+		gocv.Dilate(d.thresh, &d.thresh, d.kernel)
+		start = observe("diff", start)
+	}
+
+	var (
+		dot      image.Point
+		dotFound bool
+	)
+	if d.laser != nil {
+		dot, dotFound = d.laser.Find(d.frame)
+		d.info.Dot, d.info.DotFound = dot, dotFound
+		start = observe("laser", start)
 	}
 
-	d.streamer.StreamFrame(d.frame)
+	var dets []event.Detection
+	for _, c := range motionContours(d.thresh.Clone(), d.area) {
+		rect := gocv.BoundingRect(c.points)
+		var velocity event.Velocity
+		if d.flow != nil {
+			var fast bool
+			if velocity, fast = d.flow.velocity(rect, d.gray.Cols()); !fast {
+				d.debug.Debug("Ignoring slow region", "rect", rect.String(), "velocity_x", velocity.X, "velocity_y", velocity.Y)
+				continue
+			}
+		}
+		dets = append(dets, event.Detection{
+			Frame:      frame,
+			Rect:       rect,
+			Area:       c.area,
+			Confidence: confidence(c.area, rect),
+			Velocity:   velocity,
+			Dot:        dot,
+			DotFound:   dotFound,
+			OnTurret:   d.ego != nil,
+			Camera:     camera,
+		})
+	}
+	start = observe("contours", start)
+
+	det, ok := d.choose(dets, readAt)
+	d.info.Detections, d.info.Target, d.info.TargetFound = dets, det, ok
+	if ok {
+		metrics.Detections.Inc()
+		metrics.DetectionRate.Mark()
+		det.DecidedAt = time.Now()
+		d.info.Target = det
+		metrics.CaptureToDecision.Observe(det.DecidedAt.Sub(readAt).Seconds())
+		d.debug.Debug("Motion detected", "seq", frame.Seq, "rect", det.Rect.String(), "area", det.Area, "confidence", det.Confidence, "track", det.TrackID, "dot", dot.String(), "dot_found", dotFound)
+		d.handler(det)
+		start = observe("handler", start)
+	}
+	return d.stream(start)
+}
+
+// stream draws the overlays on a copy of the frame and streams
+// each type of image.
+func (d *Detector) stream(start time.Time) error {
+	d.info.Latency = start.Sub(d.info.Frame.CapturedAt)
+	d.info.FPS = metrics.ProcessedFPS.Value()
+	if raw, ok := d.streamer.(RawStreamer); ok {
+		raw.StreamRaw(d.frame)
+	}
+	d.frame.CopyTo(&d.annotated)
+	for _, o := range d.overlays {
+		o(&d.annotated, d.info)
+	}
+	d.streamer.StreamFrame(d.annotated)
 	d.streamer.StreamDelta(d.delta)
 	d.streamer.StreamThresh(d.thresh)
+	observe("stream", start)
+	return nil
+}
 
-	return false
+// observe records the time elapsed since start as the latency
+// of stage and returns the current time.
+func observe(stage string, start time.Time) time.Time {
+	now := time.Now()
+	metrics.StageLatency.With(stage).Observe(now.Sub(start).Seconds())
+	return now
+}
+
+// Close closes a detector that is not running, Run closes the
+// detector once it returns.
+func (d *Detector) Close() error {
+	return d.close()
 }
 
 // close closes the detector.
 func (d *Detector) close() error {
-	d.firstFrame.Close()
-	d.frame.Close()
-	d.gray.Close()
-	d.delta.Close()
-	d.thresh.Close()
-	d.kernel.Close()
-	return d.video.Close()
-}
-
-// bestContour obtains the biggest contour in the frame(provided is bigger)
-// than the minArea.
-func bestContour(frame gocv.Mat, minArea float64) []image.Point {
-	cnts := gocv.FindContours(frame, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	var result *multierror.Error
+	for _, m := range []*gocv.Mat{&d.firstFrame, &d.frame, &d.gray, &d.delta, &d.thresh, &d.kernel, &d.annotated} {
+		if err := m.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if d.laser != nil {
+		if err := d.laser.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if d.ego != nil {
+		if err := d.ego.close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if d.flow != nil {
+		if err := d.flow.close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if d.lens != nil {
+		if err := d.lens.close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if err := d.video.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
+
+// choose returns the detection the turrets engage. Without a
+// selector it is the biggest one.
+func (d *Detector) choose(dets []event.Detection, at time.Time) (event.Detection, bool) {
+	if d.selector != nil {
+		return d.selector.Select(dets, at)
+	}
 	var (
-		bestCnt  []image.Point
-		bestArea = minArea
+		best  event.Detection
+		found bool
 	)
-	for _, cnt := range cnts {
-		if area := gocv.ContourArea(cnt); area > bestArea {
-			bestArea = area
-			bestCnt = cnt
+	for _, det := range dets {
+		if !found || det.Area > best.Area {
+			best, found = det, true
+		}
+	}
+	return best, found
+}
+
+// contour is a contour and its area.
+type contour struct {
+	points []image.Point
+	area   float64
+}
+
+// motionContours obtains the contours in the frame that are bigger
+// than minArea.
+func motionContours(frame gocv.Mat, minArea float64) []contour {
+	defer frame.Close()
+	var cnts []contour
+	for _, cnt := range gocv.FindContours(frame, gocv.RetrievalExternal, gocv.ChainApproxSimple) {
+		if area := gocv.ContourArea(cnt); area > minArea {
+			cnts = append(cnts, contour{points: cnt, area: area})
 		}
 	}
-	return bestCnt
+	return cnts
+}
+
+// confidence is the fraction of the bounding rectangle filled by
+// the contour. Solid objects fill most of it while noise and
+// shadows produce sparse, irregular contours.
+func confidence(area float64, rect image.Rectangle) float64 {
+	rectArea := float64(rect.Dx() * rect.Dy())
+	if rectArea == 0 {
+		return 0
+	}
+	return math.Min(1, area/rectArea)
 }
 
 func convertFrame(src gocv.Mat, dst *gocv.Mat) {
@@ -0,0 +1,121 @@
+package detector
+
+import (
+	"image"
+	"image/color"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"gocv.io/x/gocv"
+)
+
+// Mount is the turret a camera is mounted on. *turret.Turret
+// implements it.
+type Mount interface {
+	// Position returns the angles the servos were last commanded to.
+	Position() (x, y float64)
+	// LastMove returns the time at which the servos were last
+	// commanded to a new position.
+	LastMove() time.Time
+}
+
+const (
+	// egoFeatures is the maximum number of features tracked to
+	// estimate the motion of the camera.
+	egoFeatures = 100
+	// egoMinFeatures is the minimum number of features tracked
+	// between the background and the frame to trust the estimate.
+	egoMinFeatures = 8
+)
+
+// egoMotion estimates the global motion of the frames of a camera
+// that moves with the turret and compensates it, so only objects
+// that move relative to the scene are detected.
+type egoMotion struct {
+	mount Mount
+	// settle is the time the servos take to stop after a command.
+	settle time.Duration
+	// moving is true while the servos were moving in the last frame.
+	moving bool
+
+	corners gocv.Mat
+	next    gocv.Mat
+	status  gocv.Mat
+	errs    gocv.Mat
+	shift   gocv.Mat
+	warped  gocv.Mat
+}
+
+func newEgoMotion(m Mount, settle time.Duration) *egoMotion {
+	return &egoMotion{
+		mount:   m,
+		settle:  settle,
+		corners: gocv.NewMat(),
+		next:    gocv.NewMat(),
+		status:  gocv.NewMat(),
+		errs:    gocv.NewMat(),
+		shift:   gocv.NewMatWithSize(2, 3, gocv.MatTypeCV64F),
+		warped:  gocv.NewMat(),
+	}
+}
+
+// estimate returns the translation from the background to the
+// frame, estimated as the median of the optical flow of the
+// strongest corners of the background. ok is false if there
+// were not enough features to track.
+func (e *egoMotion) estimate(bg, frame gocv.Mat) (shift image.Point, ok bool) {
+	gocv.GoodFeaturesToTrack(bg, &e.corners, egoFeatures, 0.01, 10)
+	if e.corners.Rows() < egoMinFeatures {
+		return image.Point{}, false
+	}
+	gocv.CalcOpticalFlowPyrLK(bg, frame, e.corners, e.next, &e.status, &e.errs)
+
+	var dxs, dys []float64
+	for i := 0; i < e.corners.Rows(); i++ {
+		if e.status.GetUCharAt(i, 0) == 0 {
+			continue
+		}
+		from, to := e.corners.GetVecfAt(i, 0), e.next.GetVecfAt(i, 0)
+		dxs = append(dxs, float64(to[0]-from[0]))
+		dys = append(dys, float64(to[1]-from[1]))
+	}
+	if len(dxs) < egoMinFeatures {
+		return image.Point{}, false
+	}
+	return image.Pt(int(median(dxs)), int(median(dys))), true
+}
+
+// compensate returns the background shifted by the motion of the
+// camera since it was taken.
+func (e *egoMotion) compensate(bg, frame gocv.Mat) gocv.Mat {
+	shift, ok := e.estimate(bg, frame)
+	if !ok || shift == (image.Point{}) {
+		return bg
+	}
+	e.shift.SetDoubleAt(0, 0, 1)
+	e.shift.SetDoubleAt(0, 1, 0)
+	e.shift.SetDoubleAt(0, 2, float64(shift.X))
+	e.shift.SetDoubleAt(1, 0, 0)
+	e.shift.SetDoubleAt(1, 1, 1)
+	e.shift.SetDoubleAt(1, 2, float64(shift.Y))
+	// the border is replicated so the part of the scene that
+	// is not in the background does not look like motion.
+	gocv.WarpAffineWithParams(bg, &e.warped, e.shift, image.Pt(bg.Cols(), bg.Rows()), gocv.InterpolationLinear, gocv.BorderReplicate, color.RGBA{})
+	return e.warped
+}
+
+func (e *egoMotion) close() error {
+	var result *multierror.Error
+	for _, m := range []*gocv.Mat{&e.corners, &e.next, &e.status, &e.errs, &e.shift, &e.warped} {
+		if err := m.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func median(vs []float64) float64 {
+	sort.Float64s(vs)
+	return vs[len(vs)/2]
+}
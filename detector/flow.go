@@ -0,0 +1,126 @@
+package detector
+
+import (
+	"image"
+	"math"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/matipan/dartagnan/event"
+	"gocv.io/x/gocv"
+)
+
+// flowPixelSpeed is the minimum optical flow, in pixels per frame,
+// of a pixel to be considered in motion.
+const flowPixelSpeed = 1
+
+// flow detects motion with the dense optical flow between two
+// consecutive frames, which also gives the velocity of each
+// region in motion.
+type flow struct {
+	// minSpeed is the minimum speed in pixels per second of a
+	// region. Slower regions are drifting, such as shadows or
+	// the light changing, and are not detected.
+	minSpeed float64
+
+	prev     gocv.Mat
+	prevTime time.Time
+	flow     gocv.Mat
+	mag      gocv.Mat
+	norm     gocv.Mat
+	mask     gocv.Mat
+	// fx and fy are the horizontal and vertical components of
+	// the flow of the last frame.
+	fx, fy []float32
+	// dt is the time between the last two frames.
+	dt time.Duration
+}
+
+func newFlow(minSpeed float64) *flow {
+	return &flow{
+		minSpeed: minSpeed,
+		prev:     gocv.NewMat(),
+		flow:     gocv.NewMat(),
+		mag:      gocv.NewMat(),
+		norm:     gocv.NewMat(),
+		mask:     gocv.NewMat(),
+	}
+}
+
+// restart makes gray, read at now, the previous frame so the flow
+// is computed from it.
+func (f *flow) restart(gray gocv.Mat, now time.Time) {
+	gray.CopyTo(&f.prev)
+	f.prevTime = now
+}
+
+// motion computes the flow from the previous frame to gray, which
+// was read at now, minus the motion of the camera shift. The
+// magnitude of the flow is written to delta and the pixels in
+// motion to thresh.
+func (f *flow) motion(gray gocv.Mat, now time.Time, shift image.Point, delta, thresh *gocv.Mat) {
+	defer f.restart(gray, now)
+	f.dt = now.Sub(f.prevTime)
+	gocv.CalcOpticalFlowFarneback(f.prev, gray, &f.flow, 0.5, 3, 15, 3, 5, 1.2, 0)
+
+	xy := gocv.Split(f.flow)
+	defer func() {
+		for _, m := range xy {
+			m.Close()
+		}
+	}()
+	if shift != (image.Point{}) {
+		gocv.AddWeighted(xy[0], 1, xy[0], 0, -float64(shift.X), &xy[0])
+		gocv.AddWeighted(xy[1], 1, xy[1], 0, -float64(shift.Y), &xy[1])
+	}
+	gocv.Magnitude(xy[0], xy[1], &f.mag)
+	// the components are kept to measure the velocity of the regions.
+	fx, _ := xy[0].DataPtrFloat32()
+	fy, _ := xy[1].DataPtrFloat32()
+	f.fx = append(f.fx[:0], fx...)
+	f.fy = append(f.fy[:0], fy...)
+
+	gocv.Normalize(f.mag, &f.norm, 0, 255, gocv.NormMinMax)
+	f.norm.ConvertTo(delta, gocv.MatTypeCV8U)
+	gocv.Threshold(f.mag, &f.mask, flowPixelSpeed, 255, gocv.ThresholdBinary)
+	f.mask.ConvertTo(thresh, gocv.MatTypeCV8U)
+}
+
+// velocity returns the mean velocity, in pixels per second, of the
+// pixels in motion inside rect and whether the region is faster
+// than the minimum speed.
+func (f *flow) velocity(rect image.Rectangle, cols int) (event.Velocity, bool) {
+	var (
+		sx, sy float64
+		n      int
+	)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := y*cols + x
+			if i >= len(f.fx) {
+				continue
+			}
+			vx, vy := float64(f.fx[i]), float64(f.fy[i])
+			if vx*vx+vy*vy < flowPixelSpeed*flowPixelSpeed {
+				continue
+			}
+			sx, sy, n = sx+vx, sy+vy, n+1
+		}
+	}
+	if n == 0 || f.dt <= 0 {
+		return event.Velocity{}, false
+	}
+	perSecond := float64(time.Second) / float64(f.dt)
+	v := event.Velocity{X: sx / float64(n) * perSecond, Y: sy / float64(n) * perSecond}
+	return v, math.Hypot(v.X, v.Y) >= f.minSpeed
+}
+
+func (f *flow) close() error {
+	var result *multierror.Error
+	for _, m := range []*gocv.Mat{&f.prev, &f.flow, &f.mag, &f.norm, &f.mask} {
+		if err := m.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,106 @@
+package detector
+
+import (
+	"image"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// HSVRange is a range of colors in the HSV space of OpenCV, where
+// the hue goes from 0 to 180 and the saturation and value from 0
+// to 255.
+type HSVRange struct {
+	Lower, Upper gocv.Scalar
+}
+
+// Color ranges of the dot of the usual laser pointers. Red wraps
+// around the hue so it needs two ranges.
+var (
+	RedLaser = []HSVRange{
+		{Lower: gocv.NewScalar(0, 70, 200, 0), Upper: gocv.NewScalar(10, 255, 255, 0)},
+		{Lower: gocv.NewScalar(160, 70, 200, 0), Upper: gocv.NewScalar(180, 255, 255, 0)},
+	}
+	GreenLaser = []HSVRange{
+		{Lower: gocv.NewScalar(40, 70, 200, 0), Upper: gocv.NewScalar(85, 255, 255, 0)},
+	}
+)
+
+// LaserColor returns the color ranges of the laser with the
+// given name, red or green.
+func LaserColor(name string) ([]HSVRange, error) {
+	switch name {
+	case "red":
+		return RedLaser, nil
+	case "green":
+		return GreenLaser, nil
+	}
+	return nil, errors.Errorf("unknown laser color %q", name)
+}
+
+// Laser finds the dot of a laser pointer in a frame. The dot is
+// the biggest bright and saturated blob of the laser's color that
+// is smaller than MaxArea.
+type Laser struct {
+	ranges  []HSVRange
+	maxArea float64
+
+	hsv  gocv.Mat
+	mask gocv.Mat
+	tmp  gocv.Mat
+}
+
+// NewLaser creates a laser dot finder for the color ranges. Blobs
+// bigger than maxArea pixels are not considered dots.
+func NewLaser(ranges []HSVRange, maxArea float64) *Laser {
+	return &Laser{
+		ranges:  ranges,
+		maxArea: maxArea,
+		hsv:     gocv.NewMat(),
+		mask:    gocv.NewMat(),
+		tmp:     gocv.NewMat(),
+	}
+}
+
+// Find returns the middle of the laser dot in the BGR frame and
+// whether it was found.
+func (l *Laser) Find(frame gocv.Mat) (image.Point, bool) {
+	if len(l.ranges) == 0 {
+		return image.Point{}, false
+	}
+	gocv.CvtColor(frame, &l.hsv, gocv.ColorBGRToHSV)
+	gocv.InRangeWithScalar(l.hsv, l.ranges[0].Lower, l.ranges[0].Upper, &l.mask)
+	for _, r := range l.ranges[1:] {
+		gocv.InRangeWithScalar(l.hsv, r.Lower, r.Upper, &l.tmp)
+		gocv.BitwiseOr(l.mask, l.tmp, &l.mask)
+	}
+
+	var (
+		dot  image.Rectangle
+		best float64 = -1
+	)
+	for _, cnt := range gocv.FindContours(l.mask, gocv.RetrievalExternal, gocv.ChainApproxSimple) {
+		area := gocv.ContourArea(cnt)
+		if area > l.maxArea || area <= best {
+			continue
+		}
+		best = area
+		dot = gocv.BoundingRect(cnt)
+	}
+	if best < 0 {
+		return image.Point{}, false
+	}
+	return image.Pt((dot.Min.X+dot.Max.X)/2, (dot.Min.Y+dot.Max.Y)/2), true
+}
+
+// Close releases the resources of the laser dot finder.
+func (l *Laser) Close() error {
+	var result *multierror.Error
+	for _, m := range []*gocv.Mat{&l.hsv, &l.mask, &l.tmp} {
+		if err := m.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,127 @@
+package detector
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// Lens holds the intrinsics of a fisheye camera, as computed by
+// the fisheye calibration of OpenCV, for frames of Width x Height.
+type Lens struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// FX and FY are the focal lengths and CX and CY the principal
+	// point, in pixels.
+	FX float64 `json:"fx"`
+	FY float64 `json:"fy"`
+	CX float64 `json:"cx"`
+	CY float64 `json:"cy"`
+	// D are the distortion coefficients k1 to k4.
+	D [4]float64 `json:"d"`
+}
+
+// Validate checks that the lens can be used to undistort frames.
+func (l Lens) Validate() error {
+	if l.Width <= 0 || l.Height <= 0 {
+		return errors.New("the size of the calibrated frames must be positive")
+	}
+	if l.FX <= 0 || l.FY <= 0 {
+		return errors.New("the focal lengths must be positive")
+	}
+	return nil
+}
+
+// Scale returns the intrinsics of the lens for frames of the given
+// size. The distortion coefficients do not depend on the size.
+func (l Lens) Scale(size image.Point) Lens {
+	sx := float64(size.X) / float64(l.Width)
+	sy := float64(size.Y) / float64(l.Height)
+	return Lens{
+		Width:  size.X,
+		Height: size.Y,
+		FX:     l.FX * sx,
+		FY:     l.FY * sy,
+		CX:     l.CX * sx,
+		CY:     l.CY * sy,
+		D:      l.D,
+	}
+}
+
+// LoadLens reads the lens from the JSON calibration file.
+func LoadLens(path string) (Lens, error) {
+	var l Lens
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return l, errors.Wrap(err, "Could not read calibration file")
+	}
+	if err := json.Unmarshal(b, &l); err != nil {
+		return l, errors.Wrapf(err, "Could not parse calibration file %s", path)
+	}
+	return l, errors.Wrapf(l.Validate(), "Invalid calibration file %s", path)
+}
+
+// Save writes the lens to the JSON calibration file.
+func (l Lens) Save(path string) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.WriteFile(path, append(b, '\n'), 0644), "Could not write calibration file")
+}
+
+// undistorter removes the distortion of the lens from frames.
+type undistorter struct {
+	lens Lens
+	// size is the size of the frames the matrices were built for.
+	size    image.Point
+	k, d    gocv.Mat
+	scratch gocv.Mat
+}
+
+func newUndistorter(l Lens) *undistorter {
+	return &undistorter{
+		lens:    l,
+		k:       gocv.NewMatWithSize(3, 3, gocv.MatTypeCV64F),
+		d:       gocv.NewMatWithSize(4, 1, gocv.MatTypeCV64F),
+		scratch: gocv.NewMat(),
+	}
+}
+
+// undistort undistorts img in place. The intrinsics are scaled to
+// the size of img, so it must have the aspect ratio of the frames
+// used for the calibration unless the camera stretches them.
+func (u *undistorter) undistort(img *gocv.Mat) {
+	size := image.Pt(img.Cols(), img.Rows())
+	if size != u.size {
+		u.size = size
+		l := u.lens.Scale(size)
+		u.k.SetTo(gocv.NewScalar(0, 0, 0, 0))
+		u.k.SetDoubleAt(0, 0, l.FX)
+		u.k.SetDoubleAt(0, 2, l.CX)
+		u.k.SetDoubleAt(1, 1, l.FY)
+		u.k.SetDoubleAt(1, 2, l.CY)
+		u.k.SetDoubleAt(2, 2, 1)
+		for i, c := range l.D {
+			u.d.SetDoubleAt(i, 0, c)
+		}
+	}
+	// the new camera matrix is the calibrated one, so the middle of
+	// the frame keeps its scale and the stretched edges are cropped.
+	gocv.FisheyeUndistortImageWithParams(*img, &u.scratch, u.k, u.d, u.k, size)
+	*img, u.scratch = u.scratch, *img
+}
+
+func (u *undistorter) close() error {
+	var result *multierror.Error
+	for _, m := range []*gocv.Mat{&u.k, &u.d, &u.scratch} {
+		if err := m.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
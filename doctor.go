@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+const (
+	piBlasterPath = "/dev/pi-blaster"
+	i2cBusPath    = "/dev/i2c-1"
+)
+
+func doctorCmd(args []string) error {
+	fs := newFlagSet("doctor", "[flags]", "Checks that the hardware needed by the turret is available.")
+	device := fs.Int("device", 0, "device ID for the camera")
+	i2cBus := fs.String("i2c", i2cBusPath, "path of the I2C bus")
+	fs.Parse(args)
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"camera", func() error { return checkCamera(*device) }},
+		{"pi-blaster", checkPiBlaster},
+		{"i2c", func() error { return checkI2C(*i2cBus) }},
+	}
+
+	failed := 0
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed++
+			fmt.Printf("[FAIL] %-10s %v\n", c.name, err)
+			continue
+		}
+		fmt.Printf("[ OK ] %s\n", c.name)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// checkCamera opens the camera and reads a frame from it.
+func checkCamera(device int) error {
+	video, err := gocv.VideoCaptureDevice(device)
+	if err != nil {
+		return errors.Wrapf(err, "Could not open capture device %d", device)
+	}
+	defer video.Close()
+
+	frame := gocv.NewMat()
+	defer frame.Close()
+	if !video.Read(&frame) || frame.Empty() {
+		return errors.Errorf("Could not read a frame from capture device %d", device)
+	}
+	return nil
+}
+
+// checkPiBlaster checks that the pi-blaster FIFO exists and can be written.
+func checkPiBlaster() error {
+	f, err := os.OpenFile(piBlasterPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return errors.Wrap(err, "pi-blaster is not writable, is the daemon running?")
+	}
+	return f.Close()
+}
+
+// checkI2C checks that the I2C bus device is present.
+func checkI2C(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "I2C bus not found, is it enabled in raspi-config?")
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return errors.Errorf("%s is not a device", path)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package event defines the events that flow from the detector to
+// the consumers of detections, such as the turrets and the trigger.
+// It does not depend on OpenCV so consumers can be built and tested
+// without it.
+package event
+
+import (
+	"image"
+	"time"
+)
+
+// Frame identifies a frame read from the camera.
+type Frame struct {
+	// Seq is the number of the frame, starting at 1 with the
+	// background.
+	Seq uint64
+	// CapturedAt is when the frame was read from the camera.
+	CapturedAt time.Time
+}
+
+// Detection is an object in motion found in a frame.
+type Detection struct {
+	// Frame is the frame where the object was found.
+	Frame Frame
+	// DecidedAt is when the detector chose the object as the
+	// target and sent it to the handlers.
+	DecidedAt time.Time
+	// Rect is the bounding rectangle of the object in the
+	// processed image.
+	Rect image.Rectangle
+	// Area is the area of the object's contour in pixels.
+	Area float64
+	// Confidence is how likely it is that the detection is a
+	// real object, between 0 and 1.
+	Confidence float64
+	// TrackID identifies the object across frames, it is 0 if the
+	// object is not tracked.
+	TrackID int
+	// Velocity is the velocity of the object in pixels per second
+	// of the processed image. It is zero if the detector does not
+	// measure it.
+	Velocity Velocity
+	// Dot is where the laser dot was found in the processed image,
+	// it is only set if DotFound is true. The difference between the
+	// dot and the middle of the detection is the aiming error.
+	Dot      image.Point
+	DotFound bool
+	// OnTurret is true if the camera is mounted on the turret, the
+	// position of the detection is then relative to Camera, the
+	// angles of the turret when the frame was captured.
+	OnTurret bool
+	Camera   Angles
+	// Range is the distance in meters to the target measured by
+	// a rangefinder, it is zero if there is no reading.
+	Range float64
+}
+
+// Angles are the angles of the servos of a turret in degrees.
+type Angles struct {
+	X, Y float64
+}
+
+// Middle returns the middle point of the detection.
+func (d Detection) Middle() image.Point {
+	return image.Pt((d.Rect.Max.X-d.Rect.Min.X)/2+d.Rect.Min.X, (d.Rect.Max.Y-d.Rect.Min.Y)/2+d.Rect.Min.Y)
+}
+
+// Velocity is the velocity of a detection in pixels per second.
+type Velocity struct {
+	X, Y float64
+}
+
+// Presence is reported by a presence sensor, such as a PIR, when it
+// starts or stops sensing motion.
+type Presence struct {
+	At time.Time
+	// Active is true when the sensor starts sensing motion and
+	// false when it stops.
+	Active bool
+}
@@ -0,0 +1,802 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/geometry"
+	"github.com/matipan/dartagnan/hud"
+	"github.com/matipan/dartagnan/imu"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/power"
+	"github.com/matipan/dartagnan/rangefinder"
+	"github.com/matipan/dartagnan/target"
+	"github.com/matipan/dartagnan/trigger"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/dartagnan/wake"
+	"github.com/matipan/dartagnan/window"
+	"github.com/matipan/gobot/drivers/gpio"
+	"github.com/matipan/gobot/drivers/i2c"
+	"github.com/matipan/gobot/platforms/raspi"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+const (
+	minArea = 7000
+
+	imgSize = 500
+)
+
+// turretFlags are the flags shared by every command that
+// drives the servos.
+type turretFlags struct {
+	pinX     string
+	pinY     string
+	distance float64
+	hfov     float64
+	vfov     float64
+	centerX  float64
+	centerY  float64
+	offset   string
+	// lens is the calibration file of the lens, its intrinsics are
+	// used instead of the fields of view. It is set from the -lens
+	// flag of the detector.
+	lens    string
+	limitX  string
+	limitY  string
+	zones   zoneFlags
+	reject  bool
+	aimGain float64
+	lead    time.Duration
+	latency bool
+}
+
+func (tf *turretFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.pinX, "pin-x", "33", "pin of the servo in the X axis, a comma separated list drives several turrets")
+	fs.StringVar(&tf.pinY, "pin-y", "35", "pin of the servo in the Y axis, a comma separated list drives several turrets")
+	fs.Float64Var(&tf.distance, "distance", 0, "distance in meters from the camera to the targets, used for the parallax between the camera and the pivot of the turrets, 0 ignores it")
+	fs.Float64Var(&tf.hfov, "hfov", 62.2, "horizontal field of view of the camera in degrees")
+	fs.Float64Var(&tf.vfov, "vfov", 48.8, "vertical field of view of the camera in degrees")
+	fs.Float64Var(&tf.centerX, "center-x", 73, "angle of the servo in the X axis that aims along the optical axis of the camera")
+	fs.Float64Var(&tf.centerY, "center-y", 20, "angle of the servo in the Y axis that aims along the optical axis of the camera")
+	fs.StringVar(&tf.offset, "pivot-offset", "0,0,0", "position in meters of the camera relative to the pivot of the turrets as right,up,forward")
+	fs.StringVar(&tf.limitX, "limit-x", "0:180", "soft limits of the servo in the X axis as min:max")
+	fs.StringVar(&tf.limitY, "limit-y", "0:180", "soft limits of the servo in the Y axis as min:max")
+	fs.Var(&tf.zones, "zone", "forbidden zone as name:minX:maxX:minY:maxY, can be repeated")
+	fs.BoolVar(&tf.reject, "limit-reject", false, "reject the commands that violate the limits instead of clamping them")
+	fs.DurationVar(&tf.lead, "lead", 0, "time the turrets aim ahead of moving targets, it needs a detector that measures velocity")
+	fs.BoolVar(&tf.latency, "compensate-latency", true, "aim further ahead of moving targets by the measured latency from capture to servo command")
+	fs.Float64Var(&tf.aimGain, "aim-gain", 0.3, "fraction of the error between the laser dot and the target corrected on each detection")
+}
+
+// limits returns the limits described by the flags.
+func (tf *turretFlags) limits() (turret.Limits, error) {
+	l := turret.Limits{Zones: tf.zones, Reject: tf.reject}
+	var err error
+	if l.MinX, l.MaxX, err = turret.ParseRange(tf.limitX); err != nil {
+		return l, errors.Wrap(err, "invalid -limit-x")
+	}
+	if l.MinY, l.MaxY, err = turret.ParseRange(tf.limitY); err != nil {
+		return l, errors.Wrap(err, "invalid -limit-y")
+	}
+	return l, nil
+}
+
+// geometry returns the geometry of the camera and the turrets
+// described by the flags.
+func (tf *turretFlags) geometry() (geometry.Geometry, error) {
+	g := geometry.Geometry{
+		Camera:   geometry.FromFOV(imgSize, imgSize, tf.hfov, tf.vfov),
+		Center:   event.Angles{X: tf.centerX, Y: tf.centerY},
+		Distance: tf.distance,
+	}
+	if tf.hfov <= 0 || tf.hfov >= 180 || tf.vfov <= 0 || tf.vfov >= 180 {
+		return g, errors.New("the fields of view must be between 0 and 180 degrees")
+	}
+	if tf.lens != "" {
+		l, err := detector.LoadLens(tf.lens)
+		if err != nil {
+			return g, err
+		}
+		// the frames are mirrored and resized before processing.
+		l = l.Scale(image.Pt(imgSize, imgSize))
+		g.Camera = geometry.Camera{Width: imgSize, Height: imgSize, FX: l.FX, FY: l.FY, CX: imgSize - l.CX, CY: l.CY}
+	}
+	parts := strings.Split(tf.offset, ",")
+	if len(parts) != 3 {
+		return g, errors.Errorf("invalid -pivot-offset %q, want right,up,forward", tf.offset)
+	}
+	var v [3]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return g, errors.Errorf("invalid -pivot-offset %q, want right,up,forward", tf.offset)
+		}
+		v[i] = f
+	}
+	g.Offset = geometry.Vec{X: v[0], Y: v[1], Z: v[2]}
+	return g, nil
+}
+
+// zoneFlags is a repeatable flag of forbidden zones.
+type zoneFlags []turret.Zone
+
+func (z *zoneFlags) String() string {
+	names := make([]string, len(*z))
+	for i, zone := range *z {
+		names[i] = zone.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func (z *zoneFlags) Set(s string) error {
+	zone, err := turret.ParseZone(s)
+	if err != nil {
+		return err
+	}
+	*z = append(*z, zone)
+	return nil
+}
+
+// turrets creates one turret for each pair of X and Y pins.
+func (tf *turretFlags) turrets(logger *slog.Logger) ([]*turret.Turret, error) {
+	limits, err := tf.limits()
+	if err != nil {
+		return nil, err
+	}
+	geo, err := tf.geometry()
+	if err != nil {
+		return nil, err
+	}
+	xs, ys := strings.Split(tf.pinX, ","), strings.Split(tf.pinY, ",")
+	if len(xs) != len(ys) {
+		return nil, errors.Errorf("got %d X pins and %d Y pins, each turret needs both", len(xs), len(ys))
+	}
+	ts := make([]*turret.Turret, len(xs))
+	for i := range xs {
+		l := logger
+		if l != nil && len(xs) > 1 {
+			l = l.With("turret", i)
+		}
+		t, err := turret.New(strings.TrimSpace(xs[i]), strings.TrimSpace(ys[i]), turret.Config{
+			Name:              strconv.Itoa(i),
+			Geometry:          geo,
+			Limits:            limits,
+			AimGain:           tf.aimGain,
+			Lead:              tf.lead,
+			CompensateLatency: tf.latency,
+			Logger:            l,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not create turret %d", i)
+		}
+		ts[i] = t
+	}
+	return ts, nil
+}
+
+// handlers returns the HandleMotion function of each turret. If
+// the turrets patrol, their patrols handle the motion instead. If
+// there is a trigger it engages the detections the turret it is
+// mounted on aims at.
+func handlers(ts []*turret.Turret, ps []*turret.Patrol, tr *trigger.Trigger, mount int) []detector.HandleMotion {
+	hs := make([]detector.HandleMotion, len(ts))
+	for i, t := range ts {
+		aim := t.Aim
+		if ps != nil {
+			aim = ps[i].Aim
+		}
+		hs[i] = func(d event.Detection) { aim(d) }
+		if tr != nil && i == mount {
+			hs[i] = func(d event.Detection) { tr.Engage(d, aim(d).OnTarget()) }
+		}
+	}
+	return hs
+}
+
+// detectorFlags are the flags that choose how the detector
+// finds motion.
+type detectorFlags struct {
+	method   string
+	minSpeed float64
+	lens     string
+}
+
+func (df *detectorFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&df.method, "detector", "diff", "how motion is detected, diff against the background or dense optical flow (diff or flow)")
+	fs.Float64Var(&df.minSpeed, "flow-min-speed", 30, "minimum speed in pixels per second of a region detected with optical flow")
+	fs.StringVar(&df.lens, "lens", "", "calibration file with the intrinsics of the lens, frames are undistorted with them when set")
+}
+
+// apply configures the detector as described by the flags.
+func (df *detectorFlags) apply(d *detector.Detector) error {
+	switch df.method {
+	case "diff":
+	case "flow":
+		d.UseFlow(df.minSpeed)
+	default:
+		return errors.Errorf("unknown detector %q", df.method)
+	}
+	if df.lens != "" {
+		l, err := detector.LoadLens(df.lens)
+		if err != nil {
+			return err
+		}
+		d.SetLens(l)
+	}
+	return nil
+}
+
+// cameraFlags are the flags with the settings of the camera.
+type cameraFlags struct {
+	cfg detector.CameraConfig
+}
+
+func (cf *cameraFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&cf.cfg.Width, "camera-width", 0, "width of the frames captured by the camera, 0 keeps the default")
+	fs.IntVar(&cf.cfg.Height, "camera-height", 0, "height of the frames captured by the camera, 0 keeps the default")
+	fs.Float64Var(&cf.cfg.FPS, "camera-fps", 0, "frames per second captured by the camera, 0 keeps the default")
+	fs.Float64Var(&cf.cfg.Exposure, "camera-exposure", 0, "fixed exposure of the camera in the units of the driver, 0 keeps the automatic exposure")
+	fs.Float64Var(&cf.cfg.WhiteBalance, "camera-white-balance", 0, "fixed white balance temperature of the camera in Kelvin, 0 keeps the automatic white balance")
+}
+
+// open opens the camera with the given device ID and configures it.
+// The value the driver took for each setting is logged.
+func (cf *cameraFlags) open(device int, log *slog.Logger) (*gocv.VideoCapture, error) {
+	video, err := gocv.VideoCaptureDevice(device)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not open capture device")
+	}
+	for _, p := range detector.Configure(video, cf.cfg) {
+		if !p.Accepted() {
+			log.Warn("Camera did not accept a setting", "property", p.Name, "requested", p.Requested, "actual", p.Actual)
+			continue
+		}
+		log.Info("Camera configured", "property", p.Name, "value", p.Actual)
+	}
+	return video, nil
+}
+
+// captureFlags are the flags that choose how frames are read from
+// the camera or video file.
+type captureFlags struct {
+	policy string
+}
+
+// register registers the flags, def is the default policy.
+func (cf *captureFlags) register(fs *flag.FlagSet, def string) {
+	fs.StringVar(&cf.policy, "capture", def, "how frames are read, latest reads them on another goroutine and drops the ones not processed in time, all processes every frame (latest or all)")
+}
+
+// source wraps src as described by the flags. The policy all reads
+// from src directly.
+func (cf *captureFlags) source(src detector.FrameSource) (detector.FrameSource, error) {
+	policy, err := detector.ParseCapturePolicy(cf.policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy == detector.All {
+		return src, nil
+	}
+	return detector.NewCapture(src, policy), nil
+}
+
+// targetFlags are the flags that configure how the target is chosen.
+type targetFlags struct {
+	policy      string
+	dwell       time.Duration
+	maxDistance float64
+	maxMissed   int
+}
+
+func (tf *targetFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.policy, "target-policy", "largest", "how the target is chosen ("+strings.Join(target.PolicyNames(), ", ")+")")
+	fs.DurationVar(&tf.dwell, "dwell", time.Second, "minimum time on a target before switching to another one")
+	fs.Float64Var(&tf.maxDistance, "track-distance", 80, "maximum distance in pixels an object moves between two frames")
+	fs.IntVar(&tf.maxMissed, "track-lost", 5, "number of frames in a row an object can be missing before its track is lost")
+}
+
+// selector creates the target selector described by the flags. aim
+// returns the pixel the turret aims at.
+func (tf *targetFlags) selector(aim func() image.Point, logger *slog.Logger) (*target.Selector, error) {
+	return target.NewSelector(target.Config{
+		Policy:      tf.policy,
+		Dwell:       tf.dwell,
+		MaxDistance: tf.maxDistance,
+		MaxMissed:   tf.maxMissed,
+		Aim:         aim,
+		Logger:      logger,
+	})
+}
+
+// hudFlags are the flags that configure the HUD drawn on the frames
+// and their recording.
+type hudFlags struct {
+	elements  string
+	record    string
+	recordRaw bool
+	recordFPS float64
+}
+
+func (hf *hudFlags) register(fs *flag.FlagSet) {
+	names := make([]string, len(hud.All))
+	for i, e := range hud.All {
+		names[i] = string(e)
+	}
+	fs.StringVar(&hf.elements, "hud", "all", "elements of the HUD drawn on the frames ("+strings.Join(names, ", ")+"), all or empty for none")
+	fs.StringVar(&hf.record, "record", "", "file where the frames are recorded as MJPEG, empty disables recording")
+	fs.BoolVar(&hf.recordRaw, "record-raw", false, "record the frames without the HUD")
+	fs.Float64Var(&hf.recordFPS, "record-fps", 15, "frames per second of the recording")
+}
+
+// hud creates the HUD described by the flags.
+func (hf *hudFlags) hud(src hud.Sources) (*hud.HUD, error) {
+	elements, err := hud.ParseElements(hf.elements)
+	if err != nil {
+		return nil, err
+	}
+	return hud.New(src, elements...), nil
+}
+
+// streamer returns s and the recorder, if the frames are recorded.
+// The returned function closes the recorder.
+func (hf *hudFlags) streamer(s detector.Streamer) (detector.Streamer, func() error, error) {
+	if hf.record == "" {
+		return s, func() error { return nil }, nil
+	}
+	r, err := window.NewRecorder(hf.record, hf.recordFPS, image.Pt(imgSize, imgSize), hf.recordRaw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return detector.Streamers(s, r), r.Close, nil
+}
+
+// patrolFlags are the flags that configure what the turrets do
+// while there is nothing to aim at.
+type patrolFlags struct {
+	pattern   string
+	idle      time.Duration
+	interval  time.Duration
+	home      string
+	waypoints string
+	step      float64
+	radius    float64
+}
+
+func (pf *patrolFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&pf.pattern, "patrol", "none", "what the turrets do while idle (none, home, raster, waypoints or spiral)")
+	fs.DurationVar(&pf.idle, "patrol-idle", 10*time.Second, "time without detections after which the turrets patrol")
+	fs.DurationVar(&pf.interval, "patrol-interval", 500*time.Millisecond, "time between two positions of the patrol")
+	fs.StringVar(&pf.home, "patrol-home", "90:0", "home position of the home patrol as x:y")
+	fs.StringVar(&pf.waypoints, "waypoints", "", "positions of the waypoints patrol as x:y,x:y,...")
+	fs.Float64Var(&pf.step, "patrol-step", 10, "degrees between the rows and columns of the raster patrol and between the turns of the spiral")
+	fs.Float64Var(&pf.radius, "patrol-radius", 30, "maximum radius in degrees of the spiral patrol")
+}
+
+// patrols creates a patrol for each turret, it returns nil if
+// the turrets do not patrol.
+func (pf *patrolFlags) patrols(ts []*turret.Turret) ([]*turret.Patrol, error) {
+	if pf.pattern == "none" {
+		return nil, nil
+	}
+	if pf.interval <= 0 {
+		return nil, errors.New("-patrol-interval must be positive")
+	}
+	if pf.step <= 0 || pf.step > 180 {
+		return nil, errors.New("-patrol-step must be between 0 and 180")
+	}
+	ps := make([]*turret.Patrol, len(ts))
+	for i, t := range ts {
+		var pattern turret.Pattern
+		switch pf.pattern {
+		case "home":
+			points, err := turret.ParsePoints(pf.home)
+			if err != nil || len(points) != 1 {
+				return nil, errors.Errorf("invalid -patrol-home %q, want x:y", pf.home)
+			}
+			pattern = turret.Home(points[0])
+		case "raster":
+			pattern = turret.Raster(t.Limits(), pf.step)
+		case "waypoints":
+			points, err := turret.ParsePoints(pf.waypoints)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid -waypoints")
+			}
+			pattern = &turret.Waypoints{Points: points}
+		case "spiral":
+			pattern = &turret.Spiral{Step: pf.step, Radius: pf.radius}
+		default:
+			return nil, errors.Errorf("unknown patrol %q", pf.pattern)
+		}
+		ps[i] = turret.NewPatrol(t, turret.PatrolConfig{Idle: pf.idle, Interval: pf.interval, Pattern: pattern})
+	}
+	return ps, nil
+}
+
+// triggerFlags are the flags that configure the trigger.
+type triggerFlags struct {
+	pin           string
+	kind          string
+	killPin       string
+	restAngle     uint
+	fireAngle     uint
+	lockFrames    int
+	lockRadius    int
+	lockTimeout   time.Duration
+	minConfidence float64
+	burst         time.Duration
+	cooldown      time.Duration
+	armed         bool
+	laserPin      string
+	turret        int
+}
+
+func (tf *triggerFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.pin, "trigger-pin", "", "pin of the trigger actuator, if it and -laser-pin are empty the trigger is disabled")
+	fs.StringVar(&tf.kind, "trigger-type", "relay", "type of the trigger actuator (relay, pin or servo)")
+	fs.StringVar(&tf.killPin, "kill-pin", "", "pin of the kill switch button, while it is pushed the trigger is disarmed")
+	fs.UintVar(&tf.restAngle, "trigger-rest", 0, "angle of the trigger servo at rest")
+	fs.UintVar(&tf.fireAngle, "trigger-fire", 90, "angle of the trigger servo when firing")
+	fs.IntVar(&tf.lockFrames, "lock-frames", 5, "number of consecutive stable detections before firing")
+	fs.IntVar(&tf.lockRadius, "lock-radius", 20, "maximum movement in pixels of a locked target between two frames")
+	fs.DurationVar(&tf.lockTimeout, "lock-timeout", 500*time.Millisecond, "time without detections after which the lock is lost")
+	fs.Float64Var(&tf.minConfidence, "min-confidence", 0.5, "minimum confidence of a detection to lock on it")
+	fs.DurationVar(&tf.burst, "burst", 200*time.Millisecond, "time the actuator is on when firing")
+	fs.DurationVar(&tf.cooldown, "cooldown", 2*time.Second, "time after a burst before firing again")
+	fs.StringVar(&tf.laserPin, "laser-pin", "", "pin of a laser pointer that is turned on while the trigger is locked on a target, without -trigger-pin the trigger only turns on the laser")
+	fs.BoolVar(&tf.armed, "armed", false, "arm the trigger on start, otherwise it is armed with SIGUSR1")
+	fs.IntVar(&tf.turret, "trigger-turret", 0, "index of the turret the trigger is mounted on")
+}
+
+// trigger creates the trigger mounted on one of the turrets described
+// by the flags, it returns nil if the trigger is disabled.
+func (tf *triggerFlags) trigger(ts []*turret.Turret, logger *slog.Logger) (*trigger.Trigger, error) {
+	if tf.pin == "" && tf.laserPin == "" {
+		return nil, nil
+	}
+	if tf.turret < 0 || tf.turret >= len(ts) {
+		return nil, errors.Errorf("-trigger-turret %d does not exist, there are %d turrets", tf.turret, len(ts))
+	}
+	if tf.restAngle > 180 || tf.fireAngle > 180 {
+		return nil, errors.New("trigger angles must be between 0 and 180")
+	}
+	adaptor := raspi.NewAdaptor()
+	var a trigger.Actuator
+	switch {
+	case tf.pin == "":
+		// without a trigger actuator the trigger only points the
+		// laser at the targets it locks on.
+		a = trigger.Nop{}
+	case tf.kind == "relay":
+		a = gpio.NewRelayDriver(adaptor, tf.pin)
+	case tf.kind == "pin":
+		a = gpio.NewDirectPinDriver(adaptor, tf.pin)
+	case tf.kind == "servo":
+		// the servo is driven with 20ms pulses like the servos of
+		// the turret, the adaptor's servo writes are not pulses.
+		sa := raspi.NewAdaptor()
+		sa.PiBlasterPeriod = 20000000
+		pin, err := sa.PWMPin(tf.pin)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not init pin %s", tf.pin)
+		}
+		a = trigger.ServoActuator{
+			Servo: trigger.PWMServo{Pin: pin},
+			Rest:  uint8(tf.restAngle),
+			Fire:  uint8(tf.fireAngle),
+		}
+	default:
+		return nil, errors.Errorf("unknown trigger type %q", tf.kind)
+	}
+	t, err := trigger.New(a, trigger.Config{
+		LockFrames:    tf.lockFrames,
+		LockRadius:    tf.lockRadius,
+		LockTimeout:   tf.lockTimeout,
+		MinConfidence: tf.minConfidence,
+		Burst:         tf.burst,
+		Cooldown:      tf.cooldown,
+		Logger:        logger,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create trigger")
+	}
+	if tf.laserPin != "" {
+		laser := gpio.NewDirectPinDriver(adaptor, tf.laserPin)
+		if err := laser.Off(); err != nil {
+			return nil, errors.Wrap(err, "Could not turn off the laser")
+		}
+		t.Indicate(laser)
+	}
+	if tf.killPin != "" {
+		button := trigger.Button{ButtonDriver: gpio.NewButtonDriver(adaptor, tf.killPin), Reader: adaptor}
+		if err := t.WatchKillSwitch(button); err != nil {
+			return nil, errors.Wrap(err, "Could not watch the kill switch")
+		}
+	}
+	if tf.armed {
+		if err := t.Arm(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// rangeFlags are the flags of the rangefinder mounted on the turret.
+type rangeFlags struct {
+	enabled  bool
+	interval time.Duration
+	maxAge   time.Duration
+	min      float64
+	max      float64
+}
+
+func (rf *rangeFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&rf.enabled, "rangefinder", false, "read the distance to the targets from a LIDAR-Lite on the I2C bus, -distance is used while there is no reading")
+	fs.DurationVar(&rf.interval, "range-interval", 50*time.Millisecond, "time between two readings of the rangefinder")
+	fs.DurationVar(&rf.maxAge, "range-max-age", 500*time.Millisecond, "time a reading of the rangefinder is used")
+	fs.Float64Var(&rf.min, "range-min", 0.05, "minimum distance in meters measured by the rangefinder")
+	fs.Float64Var(&rf.max, "range-max", 40, "maximum distance in meters measured by the rangefinder")
+}
+
+// rangefinder creates the rangefinder described by the flags, it
+// returns nil if it is disabled.
+func (rf *rangeFlags) rangefinder(logger *slog.Logger) (*rangefinder.Rangefinder, error) {
+	if !rf.enabled {
+		return nil, nil
+	}
+	if rf.interval <= 0 {
+		return nil, errors.New("-range-interval must be positive")
+	}
+	lidar := i2c.NewLIDARLiteDriver(raspi.NewAdaptor())
+	if err := lidar.Start(); err != nil {
+		return nil, errors.Wrap(err, "Could not start the rangefinder")
+	}
+	return rangefinder.New(lidar, rangefinder.Config{
+		Interval: rf.interval,
+		MaxAge:   rf.maxAge,
+		Min:      rf.min,
+		Max:      rf.max,
+		Logger:   logger,
+	}), nil
+}
+
+// imuFlags are the flags of the IMU mounted on the turret head.
+type imuFlags struct {
+	sensor       string
+	turret       int
+	interval     time.Duration
+	pan, tilt    string
+	forward, up  string
+	level        float64
+	timeConstant time.Duration
+	settle       time.Duration
+	tolerance    float64
+}
+
+func (mf *imuFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&mf.sensor, "imu", "", "IMU on the head of a turret that measures the angles the servos reach (mpu6050 or l3gd20h), empty disables it")
+	fs.IntVar(&mf.turret, "imu-turret", 0, "index of the turret the IMU is mounted on")
+	fs.DurationVar(&mf.interval, "imu-interval", 10*time.Millisecond, "time between two readings of the IMU")
+	fs.StringVar(&mf.pan, "imu-pan-axis", "-z", "axis of the gyroscope that measures the pan, prefixed with - if it is reversed")
+	fs.StringVar(&mf.tilt, "imu-tilt-axis", "-y", "axis of the gyroscope that measures the tilt, prefixed with - if it is reversed")
+	fs.StringVar(&mf.forward, "imu-forward-axis", "x", "axis of the accelerometer along the barrel")
+	fs.StringVar(&mf.up, "imu-up-axis", "z", "axis of the accelerometer pointing up when the head is level")
+	fs.Float64Var(&mf.level, "imu-level", 90, "angle of the tilt servo at which the head is level")
+	fs.DurationVar(&mf.timeConstant, "imu-time-constant", time.Second, "time constant of the filter that fuses the gyroscope with the accelerometer")
+	fs.DurationVar(&mf.settle, "imu-settle", 500*time.Millisecond, "time after the last command at which the angles of the servos are checked")
+	fs.Float64Var(&mf.tolerance, "imu-tolerance", 5, "maximum difference in degrees between the movement commanded and the one measured")
+}
+
+// monitor creates the monitor of the turret described by the flags,
+// it returns nil if there is no IMU.
+func (mf *imuFlags) monitor(ts []*turret.Turret, logger *slog.Logger) (*imu.Monitor, error) {
+	if mf.sensor == "" {
+		return nil, nil
+	}
+	if mf.turret < 0 || mf.turret >= len(ts) {
+		return nil, errors.Errorf("-imu-turret %d does not exist, there are %d turrets", mf.turret, len(ts))
+	}
+	if mf.interval <= 0 {
+		return nil, errors.New("-imu-interval must be positive")
+	}
+	var axes imu.Axes
+	for _, a := range []struct {
+		axis *imu.Axis
+		flag string
+		s    string
+	}{
+		{&axes.Pan, "-imu-pan-axis", mf.pan},
+		{&axes.Tilt, "-imu-tilt-axis", mf.tilt},
+		{&axes.Forward, "-imu-forward-axis", mf.forward},
+		{&axes.Up, "-imu-up-axis", mf.up},
+	} {
+		var err error
+		if *a.axis, err = imu.ParseAxis(a.s); err != nil {
+			return nil, errors.Wrap(err, a.flag)
+		}
+	}
+	var sensor imu.Sensor
+	switch mf.sensor {
+	case "mpu6050":
+		d := i2c.NewMPU6050Driver(raspi.NewAdaptor())
+		if err := d.Start(); err != nil {
+			return nil, errors.Wrap(err, "Could not start the MPU6050")
+		}
+		sensor = imu.MPU6050{Driver: d}
+	case "l3gd20h":
+		d := i2c.NewL3GD20HDriver(raspi.NewAdaptor())
+		if err := d.Start(); err != nil {
+			return nil, errors.Wrap(err, "Could not start the L3GD20H")
+		}
+		sensor = imu.L3GD20H{Driver: d}
+	default:
+		return nil, errors.Errorf("unknown IMU %q, want mpu6050 or l3gd20h", mf.sensor)
+	}
+	return imu.NewMonitor(sensor, ts[mf.turret], imu.Config{
+		Axes:         axes,
+		Interval:     mf.interval,
+		Level:        mf.level,
+		TimeConstant: mf.timeConstant,
+		Settle:       mf.settle,
+		Tolerance:    mf.tolerance,
+		Logger:       logger,
+	}), nil
+}
+
+// powerFlags are the flags of the INA3221 that monitors the supply
+// of the servos.
+type powerFlags struct {
+	enabled      bool
+	turret       int
+	channelX     int
+	channelY     int
+	interval     time.Duration
+	maxCurrent   float64
+	stallCurrent float64
+	stallTime    time.Duration
+	settle       time.Duration
+	cooldown     time.Duration
+	maxFaults    int
+	faultWindow  time.Duration
+	minVoltage   float64
+}
+
+func (pf *powerFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&pf.enabled, "ina3221", false, "monitor the current drawn by the servos of a turret with an INA3221 on the I2C bus")
+	fs.IntVar(&pf.turret, "ina-turret", 0, "index of the turret whose servos are powered through the INA3221")
+	fs.IntVar(&pf.channelX, "ina-x-channel", 1, "channel of the INA3221 the X servo is powered through, 0 if it is not monitored")
+	fs.IntVar(&pf.channelY, "ina-y-channel", 2, "channel of the INA3221 the Y servo is powered through, 0 if it is not monitored")
+	fs.DurationVar(&pf.interval, "power-interval", 20*time.Millisecond, "time between two readings of the INA3221")
+	fs.Float64Var(&pf.maxCurrent, "max-current", 1.5, "current in amps over which a servo is backed off at once")
+	fs.Float64Var(&pf.stallCurrent, "stall-current", 0.8, "current in amps over which a servo is stalled if it lasts -stall-time")
+	fs.DurationVar(&pf.stallTime, "stall-time", 500*time.Millisecond, "time a servo may draw more than -stall-current while it moves")
+	fs.DurationVar(&pf.settle, "power-settle", 500*time.Millisecond, "time a servo takes to reach a commanded angle, the angle it is backed off to is only updated after it")
+	fs.DurationVar(&pf.cooldown, "fault-cooldown", 2*time.Second, "time a servo ignores its commands after it is backed off")
+	fs.IntVar(&pf.maxFaults, "max-faults", 3, "faults within -fault-window after which a servo is disabled")
+	fs.DurationVar(&pf.faultWindow, "fault-window", time.Minute, "time during which the faults of a servo are counted")
+	fs.Float64Var(&pf.minVoltage, "min-voltage", 4.6, "supply voltage of the servos under which it is sagging, 0 disables the check")
+}
+
+// monitor creates the monitor of the turret described by the flags,
+// it returns nil if it is disabled.
+func (pf *powerFlags) monitor(ts []*turret.Turret, logger *slog.Logger) (*power.Monitor, error) {
+	if !pf.enabled {
+		return nil, nil
+	}
+	if pf.turret < 0 || pf.turret >= len(ts) {
+		return nil, errors.Errorf("-ina-turret %d does not exist, there are %d turrets", pf.turret, len(ts))
+	}
+	if pf.interval <= 0 {
+		return nil, errors.New("-power-interval must be positive")
+	}
+	if pf.maxFaults < 1 {
+		return nil, errors.New("-max-faults must be at least 1")
+	}
+	var channels []power.Channel
+	for _, c := range []struct {
+		axis    string
+		channel int
+	}{{"x", pf.channelX}, {"y", pf.channelY}} {
+		if c.channel == 0 {
+			continue
+		}
+		if c.channel < 1 || c.channel > 3 {
+			return nil, errors.Errorf("-ina-%s-channel must be between 1 and 3", c.axis)
+		}
+		channels = append(channels, power.Channel{Axis: c.axis, Channel: i2c.INA3221Channel(c.channel)})
+	}
+	ina := i2c.NewINA3221Driver(raspi.NewAdaptor())
+	if err := ina.Start(); err != nil {
+		return nil, errors.Wrap(err, "Could not start the INA3221")
+	}
+	return power.NewMonitor(ina, ts[pf.turret], power.Config{
+		Channels:     channels,
+		Interval:     pf.interval,
+		MaxCurrent:   pf.maxCurrent,
+		StallCurrent: pf.stallCurrent,
+		StallTime:    pf.stallTime,
+		Settle:       pf.settle,
+		Cooldown:     pf.cooldown,
+		MaxFaults:    pf.maxFaults,
+		FaultWindow:  pf.faultWindow,
+		MinVoltage:   pf.minVoltage,
+		Logger:       logger,
+	}), nil
+}
+
+// wakeFlags are the flags of the low-power mode woken by a PIR sensor.
+type wakeFlags struct {
+	pin      string
+	awake    time.Duration
+	sleepFPS float64
+}
+
+func (wf *wakeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&wf.pin, "pir-pin", "", "pin of a PIR sensor, the camera pipeline sleeps until it senses motion, empty disables it")
+	fs.DurationVar(&wf.awake, "awake", 30*time.Second, "time the pipeline runs at full rate after the PIR sensor stops sensing motion")
+	fs.Float64Var(&wf.sleepFPS, "sleep-fps", 1, "frames per second processed while the pipeline sleeps, 0 pauses it")
+}
+
+// wake creates the wake-up described by the flags, it returns nil if
+// there is no PIR sensor.
+func (wf *wakeFlags) wake(logger *slog.Logger) (*wake.Wake, error) {
+	if wf.pin == "" {
+		return nil, nil
+	}
+	if wf.sleepFPS < 0 {
+		return nil, errors.New("-sleep-fps cannot be negative")
+	}
+	w := wake.New(wake.Config{Awake: wf.awake, SleepFPS: wf.sleepFPS, Logger: logger})
+	w.OnPresence(func(e event.Presence) {
+		logger.Info("PIR sensor changed", "active", e.Active)
+	})
+	if err := w.Watch(gpio.NewPIRMotionDriver(raspi.NewAdaptor(), wf.pin)); err != nil {
+		return nil, errors.Wrap(err, "Could not watch the PIR sensor")
+	}
+	return w, nil
+}
+
+// logFlags are the flags that configure the loggers.
+type logFlags struct {
+	format     string
+	level      string
+	levels     string
+	file       string
+	maxSize    int64
+	maxBackups int
+}
+
+func (lf *logFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&lf.format, "log-format", "console", "format of the logs (console or json)")
+	fs.StringVar(&lf.level, "log-level", "info", "level of the logs (debug, info, warn or error)")
+	fs.StringVar(&lf.levels, "log-levels", "", "level of each subsystem, e.g. turret=debug,detector=warn")
+	fs.StringVar(&lf.file, "log-file", "", "file where logs are written instead of stderr")
+	fs.Int64Var(&lf.maxSize, "log-max-size", 10<<20, "size in bytes at which the log file is rotated")
+	fs.IntVar(&lf.maxBackups, "log-max-backups", 3, "number of rotated log files that are kept")
+}
+
+func (lf *logFlags) loggers() (*logging.Loggers, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(lf.level)); err != nil {
+		return nil, err
+	}
+	levels, err := logging.ParseLevels(lf.levels)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(logging.Config{
+		Format:     lf.format,
+		Level:      level,
+		Levels:     levels,
+		File:       lf.file,
+		MaxSize:    lf.maxSize,
+		MaxBackups: lf.maxBackups,
+	})
+}
@@ -0,0 +1,164 @@
+// Package geometry converts between the pixels of the processed
+// image and the angles of the servos that aim the turret at them,
+// modelling the camera as a pinhole.
+package geometry
+
+import (
+	"math"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/pkg/errors"
+)
+
+// Vec is a point or direction in meters. X goes to the right, Y up
+// and Z forward, along the optical axis of the camera.
+type Vec struct {
+	X, Y, Z float64
+}
+
+func (v Vec) add(o Vec) Vec       { return Vec{v.X + o.X, v.Y + o.Y, v.Z + o.Z} }
+func (v Vec) sub(o Vec) Vec       { return Vec{v.X - o.X, v.Y - o.Y, v.Z - o.Z} }
+func (v Vec) scale(s float64) Vec { return Vec{v.X * s, v.Y * s, v.Z * s} }
+func (v Vec) norm() float64       { return math.Sqrt(v.dot(v)) }
+func (v Vec) unit() Vec           { return v.scale(1 / v.norm()) }
+func (v Vec) dot(o Vec) float64   { return v.X*o.X + v.Y*o.Y + v.Z*o.Z }
+
+// angles returns the pan and tilt in radians of the direction v.
+func (v Vec) angles() (pan, tilt float64) {
+	return math.Atan2(v.X, v.Z), math.Atan2(v.Y, math.Hypot(v.X, v.Z))
+}
+
+// Camera are the intrinsics of a pinhole camera in pixels of the
+// processed image.
+type Camera struct {
+	Width, Height int
+	// FX and FY are the focal lengths and CX and CY the principal
+	// point.
+	FX, FY, CX, CY float64
+}
+
+// FromFOV returns the camera of an image of width x height with
+// the given horizontal and vertical fields of view in degrees. The
+// principal point is the middle of the image.
+func FromFOV(width, height int, hfov, vfov float64) Camera {
+	return Camera{
+		Width:  width,
+		Height: height,
+		FX:     float64(width) / 2 / math.Tan(radians(hfov)/2),
+		FY:     float64(height) / 2 / math.Tan(radians(vfov)/2),
+		CX:     float64(width) / 2,
+		CY:     float64(height) / 2,
+	}
+}
+
+// Validate checks that the camera can project points.
+func (c Camera) Validate() error {
+	if c.Width <= 0 || c.Height <= 0 {
+		return errors.New("the size of the image must be positive")
+	}
+	if c.FX <= 0 || c.FY <= 0 {
+		return errors.New("the focal lengths must be positive")
+	}
+	return nil
+}
+
+// Ray returns the direction from the camera towards the pixel.
+func (c Camera) Ray(x, y float64) Vec {
+	return Vec{X: (x - c.CX) / c.FX, Y: (c.CY - y) / c.FY, Z: 1}
+}
+
+// minDepth is the depth used to project points that are behind the
+// camera, so they end up far outside of the image.
+const minDepth = 1e-3
+
+// Project returns the pixel where the point is seen. ok is false if
+// the point is behind the camera.
+func (c Camera) Project(v Vec) (x, y float64, ok bool) {
+	ok = v.Z > 0
+	if v.Z < minDepth {
+		v.Z = minDepth
+	}
+	return c.CX + c.FX*v.X/v.Z, c.CY - c.FY*v.Y/v.Z, ok
+}
+
+// Geometry describes where the camera is with respect to the turret.
+// Angles are in degrees, pan grows to the right of the image and tilt
+// upwards.
+type Geometry struct {
+	Camera Camera
+	// Offset is the position of the camera relative to the pivot of
+	// the turret, in the axes of the camera when the servos are at
+	// Center.
+	Offset Vec
+	// Center are the angles of the servos at which the turret aims
+	// along the optical axis of the camera. If the camera is mounted
+	// on the turret it is where the camera looks ahead of the turret.
+	Center event.Angles
+	// Distance is the distance in meters from the camera to the
+	// targets. The offset causes a parallax that depends on it, if it
+	// is zero the targets are assumed to be far and it is ignored.
+	Distance float64
+}
+
+// Validate checks that the geometry can be used to aim.
+func (g Geometry) Validate() error {
+	if g.Distance < 0 {
+		return errors.New("the distance to the targets cannot be negative")
+	}
+	return g.Camera.Validate()
+}
+
+// Angles returns the angles of the servos needed to aim at the pixel
+// of an image taken by a fixed camera.
+func (g Geometry) Angles(x, y float64) event.Angles {
+	return g.aim(x, y, event.Angles{})
+}
+
+// AnglesFrom returns the angles of the servos needed to aim at the
+// pixel of an image taken by a camera mounted on the turret while
+// the servos were at the angles at.
+func (g Geometry) AnglesFrom(x, y float64, at event.Angles) event.Angles {
+	return g.aim(x, y, event.Angles{X: at.X - g.Center.X, Y: at.Y - g.Center.Y})
+}
+
+// aim returns the angles of the pixel seen by the camera rotated by
+// the angles rel from Center.
+func (g Geometry) aim(x, y float64, rel event.Angles) event.Angles {
+	v := g.Camera.Ray(x, y).unit()
+	if g.Distance > 0 {
+		v = g.Offset.add(v.scale(g.Distance))
+	}
+	pan, tilt := rotate(v, rel).angles()
+	return event.Angles{X: g.Center.X + degrees(pan), Y: g.Center.Y + degrees(tilt)}
+}
+
+// Pixel returns the pixel of an image taken by a fixed camera where
+// the turret aims with the given angles. It is the inverse of Angles
+// so it can fall outside of the image, ok is false if the turret
+// aims behind the camera.
+func (g Geometry) Pixel(a event.Angles) (x, y float64, ok bool) {
+	v := rotate(Vec{Z: 1}, event.Angles{X: a.X - g.Center.X, Y: a.Y - g.Center.Y})
+	if g.Distance > 0 {
+		// the target is the point along v that is Distance away
+		// from the camera.
+		b := v.dot(g.Offset)
+		s := g.Distance
+		if d := b*b - g.Offset.dot(g.Offset) + g.Distance*g.Distance; d >= 0 {
+			s = b + math.Sqrt(d)
+		}
+		v = v.scale(s).sub(g.Offset)
+	}
+	return g.Camera.Project(v)
+}
+
+// rotate rotates v as the turret does from Center to the angles a:
+// first the tilt around X and then the pan around Y.
+func rotate(v Vec, a event.Angles) Vec {
+	ts, tc := math.Sincos(radians(a.Y))
+	v = Vec{X: v.X, Y: v.Y*tc + v.Z*ts, Z: v.Z*tc - v.Y*ts}
+	ps, pc := math.Sincos(radians(a.X))
+	return Vec{X: v.X*pc + v.Z*ps, Y: v.Y, Z: v.Z*pc - v.X*ps}
+}
+
+func radians(deg float64) float64 { return deg * math.Pi / 180 }
+func degrees(rad float64) float64 { return rad * 180 / math.Pi }
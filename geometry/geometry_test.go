@@ -0,0 +1,125 @@
+package geometry
+
+import (
+	"math"
+	"testing"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestFromFOV(t *testing.T) {
+	c := FromFOV(500, 400, 90, 60)
+	if !near(c.FX, 250) || !near(c.FY, 200/math.Tan(math.Pi/6)) || c.CX != 250 || c.CY != 200 {
+		t.Errorf("FromFOV() = %+v", c)
+	}
+}
+
+func TestAngles(t *testing.T) {
+	g := Geometry{Camera: FromFOV(500, 500, 90, 90), Center: event.Angles{X: 90, Y: 30}}
+	tests := []struct {
+		x, y float64
+		want event.Angles
+	}{
+		{250, 250, event.Angles{X: 90, Y: 30}},
+		// the edges of the image are half of the field of view away.
+		{500, 250, event.Angles{X: 135, Y: 30}},
+		{0, 250, event.Angles{X: 45, Y: 30}},
+		{250, 0, event.Angles{X: 90, Y: 75}},
+		// a pixel off both axes is seen at a lower tilt than the
+		// pixel above the middle.
+		{500, 0, event.Angles{X: 135, Y: 30 + 35.26438968}},
+	}
+	for _, tt := range tests {
+		got := g.Angles(tt.x, tt.y)
+		if !near(got.X, tt.want.X) || !near(got.Y, tt.want.Y) {
+			t.Errorf("Angles(%v, %v) = %+v, want %+v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestAnglesParallax(t *testing.T) {
+	// the camera is 10cm to the right of the pivot, so the turret
+	// turns right to aim at a target in the middle of the image.
+	g := Geometry{
+		Camera:   FromFOV(500, 500, 90, 90),
+		Offset:   Vec{X: 0.1},
+		Center:   event.Angles{X: 90, Y: 90},
+		Distance: 1,
+	}
+	got := g.Angles(250, 250)
+	if want := 90 + degrees(math.Atan(0.1)); !near(got.X, want) || !near(got.Y, 90) {
+		t.Errorf("Angles(250, 250) = %+v, want (%v, 90)", got, want)
+	}
+
+	// far away targets have no parallax.
+	g.Distance = 0
+	if got := g.Angles(250, 250); !near(got.X, 90) {
+		t.Errorf("Angles(250, 250) without distance = %+v, want (90, 90)", got)
+	}
+}
+
+func TestPixelIsInverseOfAngles(t *testing.T) {
+	geometries := []Geometry{
+		{Camera: FromFOV(500, 500, 62, 49), Center: event.Angles{X: 73, Y: 20}},
+		{Camera: FromFOV(500, 500, 62, 49), Center: event.Angles{X: 73, Y: 20}, Offset: Vec{X: 0.05, Y: -0.1, Z: 0.02}, Distance: 2},
+	}
+	for _, g := range geometries {
+		for _, p := range [][2]float64{{250, 250}, {0, 0}, {500, 120}, {37, 480}} {
+			a := g.Angles(p[0], p[1])
+			x, y, ok := g.Pixel(a)
+			if !ok || math.Abs(x-p[0]) > 1e-6 || math.Abs(y-p[1]) > 1e-6 {
+				t.Errorf("Pixel(Angles(%v, %v)) = (%v, %v, %v) with %+v", p[0], p[1], x, y, ok, g)
+			}
+		}
+	}
+}
+
+func TestPixelBehindCamera(t *testing.T) {
+	g := Geometry{Camera: FromFOV(500, 500, 90, 90), Center: event.Angles{X: 90, Y: 90}}
+	if _, _, ok := g.Pixel(event.Angles{X: 270, Y: 90}); ok {
+		t.Error("Pixel() behind the camera ok = true")
+	}
+}
+
+func TestAnglesFrom(t *testing.T) {
+	g := Geometry{Camera: FromFOV(500, 500, 90, 90), Center: event.Angles{X: 90, Y: 20}}
+
+	// the middle of the image is where the turret aimed.
+	at := event.Angles{X: 120, Y: 45}
+	if got := g.AnglesFrom(250, 250, at); !near(got.X, at.X) || !near(got.Y, at.Y) {
+		t.Errorf("AnglesFrom(250, 250) = %+v, want %+v", got, at)
+	}
+
+	// at Center it is the same as a fixed camera.
+	for _, p := range [][2]float64{{0, 0}, {400, 100}} {
+		want := g.Angles(p[0], p[1])
+		if got := g.AnglesFrom(p[0], p[1], g.Center); !near(got.X, want.X) || !near(got.Y, want.Y) {
+			t.Errorf("AnglesFrom(%v, %v) at Center = %+v, want %+v", p[0], p[1], got, want)
+		}
+	}
+
+	// with the camera level, the edge of the image is half of the
+	// field of view away in pan.
+	if got := g.AnglesFrom(500, 250, event.Angles{X: 90, Y: 20}); !near(got.X, 135) || !near(got.Y, 20) {
+		t.Errorf("AnglesFrom(500, 250) = %+v, want (135, 20)", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Geometry{Camera: FromFOV(500, 500, 60, 60)}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	for _, g := range []Geometry{
+		{},
+		{Camera: FromFOV(500, 500, 60, 60), Distance: -1},
+		{Camera: Camera{Width: 500, Height: 500}},
+	} {
+		if err := g.Validate(); err == nil {
+			t.Errorf("Validate(%+v) error = nil", g)
+		}
+	}
+}
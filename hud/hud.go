@@ -0,0 +1,312 @@
+// Package hud draws a heads-up display on top of the frames: the
+// objects being tracked, where the turrets aim, the forbidden zones
+// and the state of the pipeline. Each element can be turned on and
+// off while the frames are streamed.
+package hud
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/imu"
+	"github.com/matipan/dartagnan/target"
+	"github.com/matipan/dartagnan/trigger"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/dartagnan/wake"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// Element is an element of the HUD.
+type Element string
+
+// Elements of the HUD.
+const (
+	// Detections are the objects in motion and the target.
+	Detections Element = "detections"
+	// Tracks are the IDs and trails of the objects being tracked.
+	Tracks Element = "tracks"
+	// Aim is where the turrets will aim at the target.
+	Aim Element = "aim"
+	// Crosshair is where the servos aim now.
+	Crosshair Element = "crosshair"
+	// Zones are the forbidden zones.
+	Zones Element = "zones"
+	// Stats are the FPS and the latency.
+	Stats Element = "stats"
+	// Status is the mode of the turrets and the state of the trigger.
+	Status Element = "status"
+)
+
+// All are all the elements of the HUD.
+var All = []Element{Detections, Tracks, Aim, Crosshair, Zones, Stats, Status}
+
+var (
+	detectionColor = color.RGBA{R: 0, G: 255, B: 0, A: 0}
+	trackColor     = color.RGBA{R: 255, G: 255, B: 0, A: 0}
+	aimColor       = color.RGBA{R: 0, G: 165, B: 255, A: 0}
+	crosshairColor = color.RGBA{R: 255, G: 255, B: 255, A: 0}
+	dotColor       = color.RGBA{R: 255, G: 0, B: 255, A: 0}
+	zoneColor      = color.RGBA{R: 255, G: 0, B: 0, A: 0}
+	textColor      = color.RGBA{R: 0, G: 0, B: 255, A: 0}
+)
+
+// ParseElements parses a comma separated list of elements, "all"
+// are all of them and an empty string none.
+func ParseElements(s string) ([]Element, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s == "all" {
+		return All, nil
+	}
+	var es []Element
+	for _, name := range strings.Split(s, ",") {
+		e, err := ParseElement(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+// ParseElement returns the element with the given name.
+func ParseElement(name string) (Element, error) {
+	for _, e := range All {
+		if string(e) == name {
+			return e, nil
+		}
+	}
+	return "", errors.Errorf("unknown HUD element %q", name)
+}
+
+// Sources are where the HUD gets what it draws besides what the
+// detector found in the frame. Every source is optional.
+type Sources struct {
+	// Turrets are the turrets whose aim and zones are drawn.
+	Turrets []*turret.Turret
+	// Patrols are the patrols of the turrets.
+	Patrols []*turret.Patrol
+	// Selector is where the tracks come from.
+	Selector *target.Selector
+	// Trigger is the trigger whose state is drawn.
+	Trigger *trigger.Trigger
+	// Lead is how far ahead the turrets aim at moving targets.
+	Lead time.Duration
+	// OnTurret is true if the camera is mounted on a turret, then
+	// the turrets always aim at the middle of the image.
+	OnTurret bool
+	// Wake is the wake-up of the pipeline, the HUD shows when it
+	// sleeps.
+	Wake *wake.Wake
+	// IMU measures the angles of the servos of a turret, the HUD
+	// shows them next to the commanded ones.
+	IMU *imu.Monitor
+}
+
+// HUD draws the heads-up display.
+type HUD struct {
+	src Sources
+
+	mu      sync.Mutex
+	enabled map[Element]bool
+}
+
+// New creates a HUD that draws the elements.
+func New(src Sources, elements ...Element) *HUD {
+	h := &HUD{src: src, enabled: make(map[Element]bool)}
+	for _, e := range elements {
+		h.enabled[e] = true
+	}
+	return h
+}
+
+// Toggle turns an element on or off.
+func (h *HUD) Toggle(e Element, on bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.enabled[e] = on
+}
+
+// Enabled returns the elements that are on.
+func (h *HUD) Enabled() []Element {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var es []Element
+	for _, e := range All {
+		if h.enabled[e] {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+func (h *HUD) on(e Element) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.enabled[e]
+}
+
+// Overlay implements the detector.Overlay function.
+func (h *HUD) Overlay(img *gocv.Mat, info detector.Info) {
+	if h.on(Zones) {
+		h.drawZones(img)
+	}
+	if h.on(Tracks) {
+		h.drawTracks(img)
+	}
+	if h.on(Detections) {
+		h.drawDetections(img, info)
+	}
+	if h.on(Aim) && info.TargetFound {
+		h.drawAim(img, info)
+	}
+	if h.on(Crosshair) {
+		h.drawCrosshair(img)
+	}
+	line := 0
+	if h.on(Status) {
+		for _, s := range h.status(info) {
+			text(img, s, &line)
+		}
+	}
+	if h.on(Stats) {
+		text(img, fmt.Sprintf("frame %d  %.1f FPS  %v", info.Frame.Seq, info.FPS, info.Latency.Round(time.Millisecond)), &line)
+	}
+}
+
+func (h *HUD) drawDetections(img *gocv.Mat, info detector.Info) {
+	for _, d := range info.Detections {
+		gocv.Rectangle(img, d.Rect, detectionColor, 1)
+	}
+	if info.TargetFound {
+		gocv.Rectangle(img, info.Target.Rect, detectionColor, 2)
+	}
+	if info.DotFound {
+		gocv.Circle(img, info.Dot, 6, dotColor, 2)
+	}
+}
+
+func (h *HUD) drawTracks(img *gocv.Mat) {
+	if h.src.Selector == nil {
+		return
+	}
+	tracks, current := h.src.Selector.Tracks()
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].ID < tracks[j].ID })
+	for _, t := range tracks {
+		thickness := 1
+		if t.ID == current {
+			thickness = 2
+		}
+		for i := 1; i < len(t.Trail); i++ {
+			gocv.Line(img, t.Trail[i-1], t.Trail[i], trackColor, thickness)
+		}
+		label := fmt.Sprintf("#%d", t.ID)
+		if t.Missed > 0 {
+			label += "?"
+		}
+		gocv.PutText(img, label, t.Detection.Rect.Min.Add(image.Pt(0, -4)), gocv.FontHersheyPlain, 1, trackColor, thickness)
+	}
+}
+
+// drawAim draws where the turrets will aim at the target: its
+// middle moved ahead by the lead, joined to the target by a line.
+func (h *HUD) drawAim(img *gocv.Mat, info detector.Info) {
+	mid := info.Target.Middle()
+	lead := h.src.Lead.Seconds()
+	aim := mid.Add(image.Pt(int(math.Round(info.Target.Velocity.X*lead)), int(math.Round(info.Target.Velocity.Y*lead))))
+	if aim != mid {
+		gocv.ArrowedLine(img, mid, aim, aimColor, 1)
+	}
+	gocv.Circle(img, aim, 8, aimColor, 2)
+}
+
+// drawCrosshair draws a crosshair where each turret aims now.
+func (h *HUD) drawCrosshair(img *gocv.Mat) {
+	const size = 12
+	for _, t := range h.src.Turrets {
+		var p image.Point
+		if h.src.OnTurret {
+			p = image.Pt(img.Cols()/2, img.Rows()/2)
+		} else {
+			p = t.Pixel(t.Position())
+		}
+		gocv.Line(img, p.Sub(image.Pt(size, 0)), p.Add(image.Pt(size, 0)), crosshairColor, 1)
+		gocv.Line(img, p.Sub(image.Pt(0, size)), p.Add(image.Pt(0, size)), crosshairColor, 1)
+		gocv.Circle(img, p, size/2, crosshairColor, 1)
+	}
+}
+
+// drawZones draws the forbidden zones as the polygons their
+// corners project to.
+func (h *HUD) drawZones(img *gocv.Mat) {
+	if h.src.OnTurret {
+		// the zones move with the camera.
+		return
+	}
+	for _, t := range h.src.Turrets {
+		for _, z := range t.Limits().Zones {
+			poly := []image.Point{
+				t.Pixel(z.MinX, z.MinY),
+				t.Pixel(z.MaxX, z.MinY),
+				t.Pixel(z.MaxX, z.MaxY),
+				t.Pixel(z.MinX, z.MaxY),
+			}
+			for i := range poly {
+				gocv.Line(img, poly[i], poly[(i+1)%len(poly)], zoneColor, 1)
+			}
+			gocv.PutText(img, z.Name, poly[3].Add(image.Pt(4, 14)), gocv.FontHersheyPlain, 1, zoneColor, 1)
+		}
+	}
+}
+
+// status returns the lines that describe the mode of the turrets
+// and the state of the trigger.
+func (h *HUD) status(info detector.Info) []string {
+	mode := "idle"
+	switch {
+	case info.Suppressed:
+		mode = "moving"
+	case info.TargetFound && info.Target.TrackID != 0:
+		mode = fmt.Sprintf("tracking #%d", info.Target.TrackID)
+	case info.TargetFound:
+		mode = "tracking"
+	default:
+		for _, p := range h.src.Patrols {
+			if p.Idle() {
+				mode = "patrolling"
+				break
+			}
+		}
+	}
+	if h.src.Wake != nil && !h.src.Wake.Awake() {
+		mode = "sleeping"
+	}
+	lines := []string{"mode: " + mode}
+	if h.src.Selector != nil {
+		lines = append(lines, "policy: "+h.src.Selector.Policy())
+	}
+	if h.src.Trigger != nil {
+		lines = append(lines, "trigger: "+h.src.Trigger.State().String())
+	}
+	if h.src.IMU != nil {
+		cx, cy := h.src.IMU.Commanded()
+		mx, my := h.src.IMU.Measured()
+		lines = append(lines, fmt.Sprintf("servos: x %.0f/%.0f y %.0f/%.0f", cx, mx, cy, my))
+	}
+	return lines
+}
+
+// text writes s on the next line of the top left corner.
+func text(img *gocv.Mat, s string, line *int) {
+	*line++
+	gocv.PutText(img, s, image.Pt(10, 20**line), gocv.FontHersheyPlain, 1.2, textColor, 2)
+}
@@ -0,0 +1,101 @@
+// Package imu estimates the orientation of the turret head from an
+// IMU mounted on it, so the angles the servos actually reach can be
+// compared with the angles they were commanded to.
+package imu
+
+import (
+	"strings"
+
+	"github.com/matipan/gobot/drivers/i2c"
+	"github.com/pkg/errors"
+)
+
+// Vec holds a value for each axis of the sensor.
+type Vec [3]float64
+
+// Reading is a sample of the IMU.
+type Reading struct {
+	// Gyro is the angular rate around each axis in degrees per second.
+	Gyro Vec
+	// Accel is the acceleration along each axis in g, it is only
+	// set if HasAccel is true.
+	Accel    Vec
+	HasAccel bool
+}
+
+// Sensor reads samples from an IMU.
+type Sensor interface {
+	Read() (Reading, error)
+}
+
+// Full scale of the MPU6050 as configured by its gobot driver: ±250
+// degrees per second and ±2g.
+const (
+	mpu6050GyroScale  = 131
+	mpu6050AccelScale = 16384
+)
+
+// MPU6050 reads the gyroscope and the accelerometer of an MPU6050.
+type MPU6050 struct {
+	Driver *i2c.MPU6050Driver
+}
+
+// Read implements the Sensor interface.
+func (m MPU6050) Read() (Reading, error) {
+	if err := m.Driver.GetData(); err != nil {
+		return Reading{}, errors.Wrap(err, "Could not read the MPU6050")
+	}
+	g, a := m.Driver.Gyroscope, m.Driver.Accelerometer
+	return Reading{
+		Gyro:     Vec{float64(g.X) / mpu6050GyroScale, float64(g.Y) / mpu6050GyroScale, float64(g.Z) / mpu6050GyroScale},
+		Accel:    Vec{float64(a.X) / mpu6050AccelScale, float64(a.Y) / mpu6050AccelScale, float64(a.Z) / mpu6050AccelScale},
+		HasAccel: true,
+	}, nil
+}
+
+// L3GD20H reads the gyroscope of an L3GD20H, it has no accelerometer
+// so the tilt is only measured by integrating the angular rate.
+type L3GD20H struct {
+	Driver *i2c.L3GD20HDriver
+}
+
+// Read implements the Sensor interface.
+func (l L3GD20H) Read() (Reading, error) {
+	x, y, z, err := l.Driver.XYZ()
+	if err != nil {
+		return Reading{}, errors.Wrap(err, "Could not read the L3GD20H")
+	}
+	return Reading{Gyro: Vec{float64(x), float64(y), float64(z)}}, nil
+}
+
+// Axis is an axis of the sensor, optionally reversed.
+type Axis struct {
+	Index int
+	Sign  float64
+}
+
+// Of returns the value of the axis in v.
+func (a Axis) Of(v Vec) float64 {
+	return a.Sign * v[a.Index]
+}
+
+// ParseAxis parses an axis as x, y or z, prefixed with a minus sign
+// if it is reversed.
+func ParseAxis(s string) (Axis, error) {
+	a := Axis{Sign: 1}
+	name := strings.TrimSpace(s)
+	if strings.HasPrefix(name, "-") {
+		a.Sign, name = -1, name[1:]
+	}
+	switch name {
+	case "x":
+		a.Index = 0
+	case "y":
+		a.Index = 1
+	case "z":
+		a.Index = 2
+	default:
+		return a, errors.Errorf("invalid axis %q, want x, y or z optionally prefixed with -", s)
+	}
+	return a, nil
+}
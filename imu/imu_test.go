@@ -0,0 +1,22 @@
+package imu
+
+import "testing"
+
+func TestParseAxis(t *testing.T) {
+	v := Vec{1, 2, 3}
+	for s, want := range map[string]float64{"x": 1, "y": 2, "z": 3, "-x": -1, " -z ": -3} {
+		a, err := ParseAxis(s)
+		if err != nil {
+			t.Errorf("ParseAxis(%q) error: %v", s, err)
+			continue
+		}
+		if got := a.Of(v); got != want {
+			t.Errorf("ParseAxis(%q).Of(%v) = %v, want %v", s, v, got, want)
+		}
+	}
+	for _, s := range []string{"", "w", "--x", "xy"} {
+		if _, err := ParseAxis(s); err == nil {
+			t.Errorf("ParseAxis(%q) error = nil", s)
+		}
+	}
+}
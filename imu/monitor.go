@@ -0,0 +1,258 @@
+package imu
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+)
+
+// Turret is the turret the IMU is mounted on. turret.Turret
+// implements it.
+type Turret interface {
+	Name() string
+	Position() (x, y float64)
+	LastMove() time.Time
+}
+
+// Axes tell how the IMU is mounted on the turret head.
+type Axes struct {
+	// Pan and Tilt are the axes of the gyroscope that measure the
+	// rotation of each servo, in the direction their angles grow.
+	Pan, Tilt Axis
+	// Forward and Up are the axes of the accelerometer along the
+	// barrel and upwards when the head is level.
+	Forward, Up Axis
+}
+
+// Config configures a monitor.
+type Config struct {
+	Axes Axes
+	// Interval is the time between two readings of the IMU.
+	Interval time.Duration
+	// Level is the angle of the tilt servo at which the head is
+	// level, the accelerometer measures the tilt from it.
+	Level float64
+	// TimeConstant is the time constant of the complementary filter.
+	// Changes faster than it are measured with the gyroscope and
+	// slower ones with the accelerometer for the tilt and with the
+	// commanded angle for the pan, which has no absolute reference.
+	TimeConstant time.Duration
+	// Settle is the time the servos take to reach a commanded angle,
+	// they are checked once it passes after the last command.
+	Settle time.Duration
+	// Tolerance is the maximum difference in degrees between the
+	// movement commanded and the one measured.
+	Tolerance float64
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Kinds of faults.
+const (
+	// Stall means the servo barely moved when it was commanded to.
+	Stall = "stall"
+	// Mismatch means the servo moved a different amount than it
+	// was commanded to.
+	Mismatch = "mismatch"
+)
+
+// Fault is a servo that did not reach the angle it was commanded to.
+type Fault struct {
+	// Axis is x for the pan and y for the tilt.
+	Axis string
+	Kind string
+	// Commanded and Measured are how many degrees the servo was
+	// commanded to move and how many it moved.
+	Commanded, Measured float64
+}
+
+// axis is the state of one servo. The movement commanded since
+// pending was set is compared with the movement measured once the
+// servo settles.
+type axis struct {
+	name      string
+	measured  float64
+	commanded float64
+	pending   bool
+	// from and start are the commanded and measured angles when the
+	// pending movement started.
+	from, start float64
+}
+
+// Monitor estimates the orientation of the turret head from the IMU
+// and detects the servos that stall or do not reach their angle.
+type Monitor struct {
+	sensor Sensor
+	turret Turret
+	cfg    Config
+
+	mu        sync.Mutex
+	started   bool
+	last      time.Time
+	pan, tilt axis
+	listeners []func(Fault)
+
+	log  *slog.Logger
+	warn *slog.Logger
+}
+
+// NewMonitor creates a monitor of the turret that reads the IMU.
+func NewMonitor(s Sensor, t Turret, cfg Config) *Monitor {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	return &Monitor{
+		sensor: s,
+		turret: t,
+		cfg:    cfg,
+		pan:    axis{name: "x"},
+		tilt:   axis{name: "y"},
+		log:    logger,
+		warn:   logging.Throttled(logger, 5*time.Second),
+	}
+}
+
+// OnFault calls f with every fault detected. f is called with no
+// locks held.
+func (m *Monitor) OnFault(f func(Fault)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, f)
+}
+
+// Measured returns the estimated angles of the servos.
+func (m *Monitor) Measured() (x, y float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pan.measured, m.tilt.measured
+}
+
+// Commanded returns the angles the servos were commanded to when the
+// IMU was last read.
+func (m *Monitor) Commanded() (x, y float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pan.commanded, m.tilt.commanded
+}
+
+// Run reads the IMU every interval until the context is closed.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r, err := m.sensor.Read()
+			if err != nil {
+				metrics.Errors.With("imu").Inc()
+				m.warn.Warn("Could not read the IMU", "err", err)
+				continue
+			}
+			m.update(now, r)
+		}
+	}
+}
+
+// update updates the estimate with a reading taken at now and
+// checks the servos that settled.
+func (m *Monitor) update(now time.Time, r Reading) {
+	cx, cy := m.turret.Position()
+	settled := now.Sub(m.turret.LastMove()) >= m.cfg.Settle
+
+	m.mu.Lock()
+	if !m.started {
+		m.started, m.last = true, now
+		m.pan.measured, m.pan.commanded = cx, cx
+		m.tilt.measured, m.tilt.commanded = cy, cy
+		if r.HasAccel {
+			m.tilt.measured = m.cfg.Level + m.pitch(r)
+		}
+		m.mu.Unlock()
+		return
+	}
+	dt := now.Sub(m.last).Seconds()
+	m.last = now
+	// k is the weight of the absolute reference in the filter.
+	k := dt / (m.cfg.TimeConstant.Seconds() + dt)
+
+	m.pan.measured += m.cfg.Axes.Pan.Of(r.Gyro) * dt
+	m.tilt.measured += m.cfg.Axes.Tilt.Of(r.Gyro) * dt
+	if r.HasAccel {
+		m.tilt.measured += k * (m.cfg.Level + m.pitch(r) - m.tilt.measured)
+	}
+
+	var faults []Fault
+	for _, a := range []struct {
+		axis     *axis
+		cmd      float64
+		absolute bool
+	}{
+		{&m.pan, cx, false},
+		{&m.tilt, cy, r.HasAccel},
+	} {
+		if f, ok := m.track(a.axis, a.cmd, settled); ok {
+			faults = append(faults, f)
+		}
+		if !a.axis.pending && !a.absolute {
+			// without an absolute reference the integrated rate
+			// drifts, once settled it is pulled to the command.
+			a.axis.measured += k * (a.axis.commanded - a.axis.measured)
+		}
+		metrics.ServoMeasured.With(m.turret.Name(), a.axis.name).Set(a.axis.measured)
+		metrics.ServoAngleError.With(m.turret.Name(), a.axis.name).Set(a.axis.commanded - a.axis.measured)
+	}
+	listeners := m.listeners
+	m.mu.Unlock()
+
+	for _, f := range faults {
+		if f.Kind == Stall {
+			metrics.ServoStalls.With(m.turret.Name(), f.Axis).Inc()
+		} else {
+			metrics.ServoMismatches.With(m.turret.Name(), f.Axis).Inc()
+		}
+		m.log.Warn("Servo did not reach the commanded angle", "axis", f.Axis, "fault", f.Kind, "commanded", f.Commanded, "measured", f.Measured)
+		for _, l := range listeners {
+			l(f)
+		}
+	}
+}
+
+// track follows the commands of the axis and checks the movement
+// once the servos settled. It must be called with mu held.
+func (m *Monitor) track(a *axis, cmd float64, settled bool) (Fault, bool) {
+	if cmd != a.commanded {
+		if !a.pending {
+			a.pending, a.from, a.start = true, a.commanded, a.measured
+		}
+		a.commanded = cmd
+	}
+	if !a.pending || !settled {
+		return Fault{}, false
+	}
+	a.pending = false
+	want, moved := a.commanded-a.from, a.measured-a.start
+	f := Fault{Axis: a.name, Commanded: want, Measured: moved}
+	switch {
+	case math.Abs(want) >= m.cfg.Tolerance && math.Abs(moved) < math.Abs(want)/2:
+		f.Kind = Stall
+	case math.Abs(moved-want) > m.cfg.Tolerance:
+		f.Kind = Mismatch
+	default:
+		return Fault{}, false
+	}
+	return f, true
+}
+
+// pitch returns the angle in degrees of the barrel above the
+// horizon measured by the accelerometer.
+func (m *Monitor) pitch(r Reading) float64 {
+	return math.Atan2(m.cfg.Axes.Forward.Of(r.Accel), m.cfg.Axes.Up.Of(r.Accel)) * 180 / math.Pi
+}
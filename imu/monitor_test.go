@@ -0,0 +1,119 @@
+package imu
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeTurret holds the angles commanded and when they were.
+type fakeTurret struct {
+	x, y  float64
+	moved time.Time
+}
+
+func (t *fakeTurret) Name() string             { return "0" }
+func (t *fakeTurret) Position() (x, y float64) { return t.x, t.y }
+func (t *fakeTurret) LastMove() time.Time      { return t.moved }
+
+var testAxes = Axes{
+	Pan:     Axis{Index: 2, Sign: 1},
+	Tilt:    Axis{Index: 1, Sign: 1},
+	Forward: Axis{Index: 0, Sign: 1},
+	Up:      Axis{Index: 2, Sign: 1},
+}
+
+// level is the accelerometer of a head that is level.
+var level = Vec{0, 0, 1}
+
+func newTestMonitor(t *fakeTurret) *Monitor {
+	return NewMonitor(nil, t, Config{
+		Axes:         testAxes,
+		Level:        90,
+		TimeConstant: time.Second,
+		Settle:       500 * time.Millisecond,
+		Tolerance:    5,
+	})
+}
+
+// rotate feeds the monitor with readings every 10ms for d at the
+// angular rate of the pan.
+func rotate(m *Monitor, now *time.Time, d time.Duration, rate float64) {
+	for end := now.Add(d); now.Before(end); {
+		*now = now.Add(10 * time.Millisecond)
+		m.update(*now, Reading{Gyro: Vec{0, 0, rate}, Accel: level, HasAccel: true})
+	}
+}
+
+func TestMonitorTracksPan(t *testing.T) {
+	start := time.Unix(0, 0)
+	tu := &fakeTurret{x: 90, y: 90}
+	m := newTestMonitor(tu)
+	var faults []Fault
+	m.OnFault(func(f Fault) { faults = append(faults, f) })
+
+	now := start
+	m.update(now, Reading{Accel: level, HasAccel: true})
+	tu.x, tu.moved = 120, now
+	// the servo turns 30 degrees in 300ms and stops.
+	rotate(m, &now, 300*time.Millisecond, 100)
+	if x, _ := m.Measured(); math.Abs(x-120) > 1 {
+		t.Errorf("Measured() x = %v, want 120", x)
+	}
+	rotate(m, &now, time.Second, 0)
+	if len(faults) != 0 {
+		t.Errorf("faults = %+v, want none", faults)
+	}
+	if x, y := m.Measured(); math.Abs(x-120) > 1 || math.Abs(y-90) > 0.1 {
+		t.Errorf("Measured() = (%v, %v), want (120, 90)", x, y)
+	}
+}
+
+func TestMonitorStall(t *testing.T) {
+	now := time.Unix(0, 0)
+	tu := &fakeTurret{x: 90, y: 90}
+	m := newTestMonitor(tu)
+	var faults []Fault
+	m.OnFault(func(f Fault) { faults = append(faults, f) })
+
+	m.update(now, Reading{Accel: level, HasAccel: true})
+	tu.x, tu.moved = 120, now
+	rotate(m, &now, time.Second, 0)
+	if len(faults) != 1 || faults[0].Axis != "x" || faults[0].Kind != Stall || faults[0].Commanded != 30 {
+		t.Fatalf("faults = %+v, want a stall of x", faults)
+	}
+}
+
+func TestMonitorMismatch(t *testing.T) {
+	now := time.Unix(0, 0)
+	tu := &fakeTurret{x: 90, y: 90}
+	m := newTestMonitor(tu)
+	var faults []Fault
+	m.OnFault(func(f Fault) { faults = append(faults, f) })
+
+	m.update(now, Reading{Accel: level, HasAccel: true})
+	tu.x, tu.moved = 120, now
+	// the servo only turns 20 of the 30 degrees.
+	rotate(m, &now, 200*time.Millisecond, 100)
+	rotate(m, &now, time.Second, 0)
+	if len(faults) != 1 || faults[0].Kind != Mismatch || math.Abs(faults[0].Measured-20) > 1 {
+		t.Fatalf("faults = %+v, want a mismatch of 20 degrees", faults)
+	}
+}
+
+func TestMonitorTiltFromAccel(t *testing.T) {
+	now := time.Unix(0, 0)
+	tu := &fakeTurret{x: 90, y: 90}
+	m := newTestMonitor(tu)
+
+	// the head is pitched 10 degrees up while the servo is at 90,
+	// the accelerometer wins over the command.
+	pitched := Vec{math.Sin(10 * math.Pi / 180), 0, math.Cos(10 * math.Pi / 180)}
+	for i := 0; i < 500; i++ {
+		now = now.Add(10 * time.Millisecond)
+		m.update(now, Reading{Accel: pitched, HasAccel: true})
+	}
+	if _, y := m.Measured(); math.Abs(y-100) > 0.1 {
+		t.Errorf("Measured() y = %v, want 100", y)
+	}
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RateLimited returns a logger that emits at most one debug record
+// with the same message every interval. Records above debug level
+// are never dropped. It is meant for logs in hot paths, such as
+// the ones written on every frame or servo movement.
+func RateLimited(l *slog.Logger, interval time.Duration) *slog.Logger {
+	return limited(l, slog.LevelDebug, interval)
+}
+
+// Throttled returns a logger that emits at most one record with the
+// same message every interval, whatever its level. It is meant for
+// warnings that can repeat on every frame.
+func Throttled(l *slog.Logger, interval time.Duration) *slog.Logger {
+	return limited(l, slog.LevelError+1, interval)
+}
+
+// limited returns a logger that rate limits the records up to level.
+func limited(l *slog.Logger, level slog.Level, interval time.Duration) *slog.Logger {
+	return slog.New(&limitHandler{
+		Handler: l.Handler(),
+		level:   level,
+		limiter: &limiter{interval: interval, last: make(map[string]time.Time)},
+	})
+}
+
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (l *limiter) allow(msg string, t time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last[msg]; ok && t.Sub(last) < l.interval {
+		return false
+	}
+	l.last[msg] = t
+	return true
+}
+
+type limitHandler struct {
+	slog.Handler
+	level   slog.Level
+	limiter *limiter
+}
+
+func (h *limitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level <= h.level && !h.limiter.allow(r.Message, r.Time) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *limitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &limitHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level, limiter: h.limiter}
+}
+
+func (h *limitHandler) WithGroup(name string) slog.Handler {
+	return &limitHandler{Handler: h.Handler.WithGroup(name), level: h.level, limiter: h.limiter}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestLogger returns a logger that writes every level to buf.
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestRateLimited(t *testing.T) {
+	var buf bytes.Buffer
+	l := RateLimited(newTestLogger(&buf), time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Debug("moved")
+		l.Debug("aimed")
+		l.Warn("stalled")
+	}
+	out := buf.String()
+	if n := strings.Count(out, "msg=moved"); n != 1 {
+		t.Errorf("debug record written %d times, want 1", n)
+	}
+	if n := strings.Count(out, "msg=aimed"); n != 1 {
+		t.Errorf("debug record with another message written %d times, want 1", n)
+	}
+	if n := strings.Count(out, "msg=stalled"); n != 3 {
+		t.Errorf("warn record written %d times, want 3", n)
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	var buf bytes.Buffer
+	l := Throttled(newTestLogger(&buf), time.Hour)
+	// the loggers derived from it share the limit.
+	l.Error("failed")
+	l.With("attempt", 2).Error("failed")
+	if n := strings.Count(buf.String(), "msg=failed"); n != 1 {
+		t.Errorf("error record written %d times, want 1", n)
+	}
+}
+
+func TestThrottledInterval(t *testing.T) {
+	var buf bytes.Buffer
+	l := Throttled(newTestLogger(&buf), time.Millisecond)
+	l.Warn("stalled")
+	time.Sleep(5 * time.Millisecond)
+	l.Warn("stalled")
+	if n := strings.Count(buf.String(), "msg=stalled"); n != 2 {
+		t.Errorf("record written %d times after the interval, want 2", n)
+	}
+}
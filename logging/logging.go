@@ -0,0 +1,114 @@
+// Package logging builds the structured loggers used by each
+// subsystem. Every subsystem can have its own level, records are
+// written as JSON or in a human readable console format and they
+// can go to a file that is rotated when it grows too big.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config configures the loggers.
+type Config struct {
+	// Format is either "console" or "json".
+	Format string
+	// Level is the level used by subsystems that are not in Levels.
+	Level slog.Level
+	// Levels holds the level of each subsystem.
+	Levels map[string]slog.Level
+	// File is the file where logs are written. If empty logs
+	// are written to stderr.
+	File string
+	// MaxSize is the size in bytes at which the file is rotated.
+	MaxSize int64
+	// MaxBackups is the number of rotated files that are kept.
+	MaxBackups int
+}
+
+// Loggers creates the logger of each subsystem.
+type Loggers struct {
+	cfg Config
+	out io.Writer
+}
+
+// New creates the loggers described by cfg.
+func New(cfg Config) (*Loggers, error) {
+	switch cfg.Format {
+	case "", "console", "json":
+	default:
+		return nil, errors.Errorf("unknown log format %q", cfg.Format)
+	}
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		f, err := NewRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return &Loggers{cfg: cfg, out: out}, nil
+}
+
+// For returns the logger of the subsystem.
+func (l *Loggers) For(subsystem string) *slog.Logger {
+	level, ok := l.cfg.Levels[subsystem]
+	if !ok {
+		level = l.cfg.Level
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if l.cfg.Format == "json" {
+		h = slog.NewJSONHandler(l.out, opts)
+	} else {
+		h = slog.NewTextHandler(l.out, opts)
+	}
+	return slog.New(h).With("subsystem", subsystem)
+}
+
+// Close closes the log file if there is one.
+func (l *Loggers) Close() error {
+	if c, ok := l.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Discard returns a logger that drops every record.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// discardHandler is a handler that is never enabled.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// ParseLevels parses a comma separated list of subsystem=level
+// pairs such as "turret=debug,detector=warn".
+func ParseLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	if s == "" {
+		return levels, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid subsystem level %q, expected subsystem=level", kv)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(parts[1])); err != nil {
+			return nil, errors.Wrapf(err, "invalid level for subsystem %s", parts[0])
+		}
+		levels[strings.TrimSpace(parts[0])] = level
+	}
+	return levels, nil
+}
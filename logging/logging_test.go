@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("turret=debug, detector=WARN,trigger=error+2")
+	if err != nil {
+		t.Fatalf("ParseLevels() error = %v", err)
+	}
+	want := map[string]slog.Level{"turret": slog.LevelDebug, "detector": slog.LevelWarn, "trigger": slog.LevelError + 2}
+	if len(levels) != len(want) {
+		t.Errorf("ParseLevels() = %v, want %v", levels, want)
+	}
+	for subsystem, level := range want {
+		if got, ok := levels[subsystem]; !ok || got != level {
+			t.Errorf("level of %s = %v, want %v", subsystem, got, level)
+		}
+	}
+
+	if levels, err := ParseLevels(""); err != nil || len(levels) != 0 {
+		t.Errorf(`ParseLevels("") = (%v, %v), want no levels`, levels, err)
+	}
+	for _, s := range []string{"turret", "turret=loud", "turret=debug,"} {
+		if _, err := ParseLevels(s); err == nil {
+			t.Errorf("ParseLevels(%q) error = nil", s)
+		}
+	}
+}
+
+func TestFor(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Loggers{cfg: Config{Format: "json", Level: slog.LevelWarn, Levels: map[string]slog.Level{"turret": slog.LevelDebug}}, out: &buf}
+
+	l.For("turret").Debug("moved")
+	l.For("detector").Info("dropped")
+	l.For("detector").Warn("stalled")
+	out := buf.String()
+	if !strings.Contains(out, `"msg":"moved","subsystem":"turret"`) {
+		t.Errorf("debug record of the turret missing in %s", out)
+	}
+	if strings.Contains(out, "dropped") {
+		t.Errorf("info record of the detector written under the default warn level: %s", out)
+	}
+	if !strings.Contains(out, `"msg":"stalled","subsystem":"detector"`) {
+		t.Errorf("warn record of the detector missing in %s", out)
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New(Config{Format: "xml"}); err == nil {
+		t.Error("New() with format xml error = nil")
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	l := Discard()
+	if l.Enabled(context.Background(), slog.LevelError) {
+		t.Error("Discard() is enabled")
+	}
+	// the loggers derived from it discard too.
+	l.With("a", 1).WithGroup("g").Error("dropped")
+}
@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RotatingFile is a file that is rotated once it reaches
+// a maximum size. Rotated files are renamed to name.1, name.2
+// and so on, where name.1 is the most recent one.
+type RotatingFile struct {
+	name       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewRotatingFile opens or creates the file called name. If maxSize
+// is not positive the file is never rotated.
+func NewRotatingFile(name string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Could not open log file %s", r.name)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Could not stat log file %s", r.name)
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write implements io.Writer. If the file cannot be rotated the
+// record is still written to it and the error is returned.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rotateErr error
+	if r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		rotateErr = r.rotate()
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// rotate moves the file to the first backup and opens a new one. The
+// current file is only closed once the new one is open, so the logs
+// keep going to it if the rotation fails.
+func (r *RotatingFile) rotate() error {
+	if _, err := os.Stat(r.name); os.IsNotExist(err) {
+		// a previous rotation moved the file but could not open
+		// a new one.
+		return r.reopen()
+	}
+	if r.maxBackups > 0 {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			err := os.Rename(backupName(r.name, i), backupName(r.name, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "Could not rotate log file")
+			}
+		}
+		if err := os.Rename(r.name, backupName(r.name, 1)); err != nil {
+			return errors.Wrap(err, "Could not rotate log file")
+		}
+	} else if err := os.Remove(r.name); err != nil {
+		return errors.Wrap(err, "Could not rotate log file")
+	}
+	return r.reopen()
+}
+
+// reopen opens the file again and closes the one it replaces.
+func (r *RotatingFile) reopen() error {
+	old := r.f
+	if err := r.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func backupName(name string, i int) string {
+	return fmt.Sprintf("%s.%d", name, i)
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
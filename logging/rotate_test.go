@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// read returns the contents of the file or "" if it does not exist.
+func read(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func write(t *testing.T, r *RotatingFile, s string) {
+	t.Helper()
+	if _, err := r.Write([]byte(s)); err != nil {
+		t.Fatalf("Write(%q) error = %v", s, err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dartagnan.log")
+	r, err := NewRotatingFile(name, 8, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	defer r.Close()
+
+	for _, s := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+		write(t, r, s)
+	}
+	// each record makes the file grow over 8 bytes, the oldest backup
+	// is dropped.
+	for file, want := range map[string]string{name: "dddd\n", name + ".1": "cccc\n", name + ".2": "bbbb\n", name + ".3": ""} {
+		if got := read(t, file); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dartagnan.log")
+	if err := os.WriteFile(name, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRotatingFile(name, 0, 0)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	write(t, r, "new\n")
+	r.Close()
+	if got, want := read(t, name), "old\nnew\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dartagnan.log")
+	r, err := NewRotatingFile(name, 8, 0)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	defer r.Close()
+	write(t, r, "aaaa\n")
+	write(t, r, "bbbb\n")
+	if got, want := read(t, name), "bbbb\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+	if got := read(t, name+".1"); got != "" {
+		t.Errorf("backup written without backups: %q", got)
+	}
+}
+
+func TestRotatingFileKeepsLoggingOnError(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "dartagnan.log")
+	r, err := NewRotatingFile(name, 8, 1)
+	if err != nil {
+		t.Fatalf("NewRotatingFile() error = %v", err)
+	}
+	defer r.Close()
+	write(t, r, "aaaa\n")
+
+	// a directory where the backup goes makes the rotation fail.
+	if err := os.MkdirAll(filepath.Join(name+".1", "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("bbbb\n")); err == nil {
+		t.Error("Write() with a failed rotation error = nil")
+	}
+	if got, want := read(t, name), "aaaa\nbbbb\n"; got != want {
+		t.Errorf("file after a failed rotation = %q, want %q", got, want)
+	}
+
+	// once the backup can be written the file rotates again.
+	if err := os.RemoveAll(name + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(t, r, "cccc\n")
+	if got, want := read(t, name), "cccc\n"; got != want {
+		t.Errorf("file = %q, want %q", got, want)
+	}
+	if got, want := read(t, name+".1"), "aaaa\nbbbb\n"; got != want {
+		t.Errorf("backup = %q, want %q", got, want)
+	}
+}
@@ -1,47 +1,69 @@
 package main
 
 import (
-	"context"
-	"log"
-	"os"
-	"os/signal"
-	"syscall"
-
 	"flag"
-
-	"github.com/matipan/dartagnan/detector"
-	"github.com/matipan/dartagnan/turret"
-	"github.com/matipan/dartagnan/window"
+	"fmt"
+	"os"
 )
 
-const minArea = 7000
+// command is a subcommand of the dartagnan binary. Each command
+// parses its own flags from args.
+type command struct {
+	name  string
+	short string
+	run   func(args []string) error
+}
 
-var (
-	area   = flag.Float64("area", minArea, "base area for motion detection")
-	device = flag.Int("device", 0, "device ID for the camera")
-)
+var commands = []command{
+	{name: "run", short: "track motion with the camera and aim the turret", run: runCmd},
+	{name: "servo", short: "exercise the servos (sweep, set)", run: servoCmd},
+	{name: "calibrate", short: "capture chessboard frames and compute the intrinsics of the lens", run: calibrateCmd},
+	{name: "snapshot", short: "capture a single frame from the camera and exit", run: snapshotCmd},
+	{name: "detect", short: "run motion detection on a video file and print the results", run: detectCmd},
+	{name: "replay", short: "replay a video file through the detector and windows", run: replayCmd},
+	{name: "sim", short: "run the detector and turret against a simulated camera and turret", run: simCmd},
+	{name: "doctor", short: "check that the camera, pi-blaster and I2C bus are available", run: doctorCmd},
+}
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-
-	t, err := turret.New("33", "35", 1.3, 500, 0)
-	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
 	}
-	wm := window.New(800, 600)
-	detector, err := detector.New(*device, *area, t.HandleMotion, wm)
-	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.short)
 	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of each command.\n", os.Args[0])
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go detector.Run(ctx)
-	<-c
-	cancel()
-	wm.Close()
+// newFlagSet creates the flag set for a subcommand with a usage
+// message that describes it.
+func newFlagSet(name, args, desc string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n\n%s\n\nFlags:\n", os.Args[0], name, args, desc)
+		fs.PrintDefaults()
+	}
+	return fs
 }
@@ -0,0 +1,87 @@
+package metrics
+
+// latencyBuckets are the buckets, in seconds, used for the latency
+// of the processing stages. They go from 0.5ms to 1s.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Metrics exported by dartagnan.
+var (
+	// CaptureFPS is the rate at which frames are read from the camera.
+	CaptureFPS = NewRate("dartagnan_capture_fps", "Frames read from the camera per second.")
+	// FramesCaptured counts the frames read from the camera.
+	FramesCaptured = NewCounter("dartagnan_frames_captured_total", "Frames read from the camera.")
+	// ProcessedFPS is the rate at which frames are processed by the detector.
+	ProcessedFPS = NewRate("dartagnan_processed_fps", "Frames processed by the detector per second.")
+	// FramesDropped counts the frames that were not processed.
+	FramesDropped = NewCounter("dartagnan_frames_dropped_total", "Frames read from the camera that were not processed.")
+	// FramesSuppressed counts the frames where detection was suppressed because the camera was moving.
+	FramesSuppressed = NewCounter("dartagnan_frames_suppressed_total", "Frames where detection was suppressed because the camera was moving.")
+	// StageLatency is the time spent on each processing stage of a frame.
+	StageLatency = NewHistogramVec("dartagnan_stage_latency_seconds", "Time spent on each processing stage of a frame.", "stage", latencyBuckets)
+
+	// CaptureToDecision is the time from reading a frame to sending its target to the handlers.
+	CaptureToDecision = NewHistogram("dartagnan_capture_to_decision_seconds", "Time from reading a frame to sending its target to the handlers.", latencyBuckets)
+	// DecisionToActuation is the time from sending a target to the handlers to commanding the servos.
+	DecisionToActuation = NewHistogram("dartagnan_decision_to_actuation_seconds", "Time from sending a target to the handlers to commanding the servos.", latencyBuckets)
+	// CaptureToActuation is the time from reading a frame to commanding the servos.
+	CaptureToActuation = NewHistogram("dartagnan_capture_to_actuation_seconds", "Time from reading a frame to commanding the servos at its target.", latencyBuckets)
+
+	// Detections counts the frames where motion was detected.
+	Detections = NewCounter("dartagnan_detections_total", "Frames where motion was detected.")
+	// DetectionRate is the rate at which motion is detected.
+	DetectionRate = NewRate("dartagnan_detections_per_second", "Frames where motion was detected per second.")
+
+	// Tracks is the number of objects being tracked.
+	Tracks = NewGauge("dartagnan_tracks", "Objects in motion being tracked.")
+	// TargetSwitches counts the times the target changed.
+	TargetSwitches = NewCounter("dartagnan_target_switches_total", "Times the target engaged by the turrets changed.")
+
+	// ServoAngle is the last angle commanded to each servo.
+	ServoAngle = NewGaugeVec("dartagnan_servo_angle_degrees", "Last angle commanded to each servo.", "turret", "axis")
+	// ServoCommands counts the commands sent to each servo.
+	ServoCommands = NewCounterVec("dartagnan_servo_commands_total", "Commands sent to each servo.", "turret", "axis")
+	// ServoCommandRate is the rate at which commands are sent to the servos.
+	ServoCommandRate = NewRate("dartagnan_servo_commands_per_second", "Commands sent to the servos per second.")
+	// ServoMeasured is the angle of each servo measured by the IMU.
+	ServoMeasured = NewGaugeVec("dartagnan_servo_measured_degrees", "Angle of each servo measured by the IMU.", "turret", "axis")
+	// ServoAngleError is the commanded angle minus the measured angle of each servo.
+	ServoAngleError = NewGaugeVec("dartagnan_servo_angle_error_degrees", "Commanded minus measured angle of each servo.", "turret", "axis")
+	// ServoStalls counts the times each servo barely moved when commanded to.
+	ServoStalls = NewCounterVec("dartagnan_servo_stalls_total", "Times the IMU measured that a servo barely moved when commanded to.", "turret", "axis")
+	// ServoMismatches counts the times each servo moved a different amount than commanded.
+	ServoMismatches = NewCounterVec("dartagnan_servo_mismatches_total", "Times the IMU measured that a servo moved a different amount than commanded.", "turret", "axis")
+	// ServoDisabled is 1 while the servo of each axis ignores its commands after repeated faults.
+	ServoDisabled = NewGaugeVec("dartagnan_servo_disabled", "1 while the servo of each axis is disabled after repeated faults.", "turret", "axis")
+	// ServoCurrent is the current drawn by each servo.
+	ServoCurrent = NewGaugeVec("dartagnan_servo_current_amps", "Current drawn by each servo.", "turret", "axis")
+	// ServoVoltage is the supply voltage of each servo.
+	ServoVoltage = NewGaugeVec("dartagnan_servo_supply_volts", "Supply voltage of each servo.", "turret", "axis")
+	// ServoCurrentFaults counts the stalls and over-currents of each servo.
+	ServoCurrentFaults = NewCounterVec("dartagnan_servo_current_faults_total", "Stalls and over-currents measured on the supply of each servo.", "turret", "axis")
+	// VoltageSags counts the times the supply voltage of the servos dropped below the minimum.
+	VoltageSags = NewCounter("dartagnan_servo_voltage_sags_total", "Times the supply voltage of the servos dropped below the minimum.")
+
+	// AimError is the distance in pixels from the laser dot to the target in each axis.
+	AimError = NewGaugeVec("dartagnan_aim_error_pixels", "Distance in pixels from the laser dot to the target.", "turret", "axis")
+	// AimCorrection is the correction applied to the angle of each servo.
+	AimCorrection = NewGaugeVec("dartagnan_aim_correction_degrees", "Correction applied to the angle of each servo from the laser dot.", "turret", "axis")
+
+	// Range is the last distance to the targets measured by the rangefinder.
+	Range = NewGauge("dartagnan_range_meters", "Last distance to the targets measured by the rangefinder.")
+
+	// Awake is 1 while the pipeline runs at full rate and 0 while it sleeps.
+	Awake = NewGauge("dartagnan_awake", "1 while the camera pipeline runs at full rate, 0 while it sleeps until the presence sensor fires.")
+	// PresenceEvents counts the times the presence sensor started sensing motion.
+	PresenceEvents = NewCounter("dartagnan_presence_events_total", "Times the presence sensor started sensing motion.")
+
+	// LimitViolations counts the commands that violated each limit of the turret.
+	LimitViolations = NewCounterVec("dartagnan_limit_violations_total", "Servo commands that violated the soft limits or a forbidden zone.", "turret", "limit")
+
+	// TriggerState is 1 for the current state of the trigger and 0 for the others.
+	TriggerState = NewGaugeVec("dartagnan_trigger_state", "State of the trigger, the gauge of the current state is 1.", "state")
+	// TriggerShots counts the bursts fired by the trigger.
+	TriggerShots = NewCounter("dartagnan_trigger_shots_total", "Bursts fired by the trigger.")
+
+	// Errors counts the errors of each subsystem.
+	Errors = NewCounterVec("dartagnan_errors_total", "Errors by subsystem.", "subsystem")
+)
@@ -0,0 +1,375 @@
+// Package metrics implements a small set of Prometheus metric types
+// and an HTTP handler that exports them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collector is a metric that can write itself in the text format.
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors []collector
+)
+
+func register(c collector) {
+	mu.Lock()
+	collectors = append(collectors, c)
+	mu.Unlock()
+}
+
+// Handler returns the handler that serves every registered metric.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		mu.Lock()
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		mu.Unlock()
+		bw.Flush()
+	})
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, typ)
+}
+
+// helpEscaper and labelEscaper escape the help and the label values
+// as the text format requires.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec holds one metric for each combination of the values of its
+// labels.
+type vec struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]interface{}
+}
+
+// key joins the label values into the key of their metric. It panics
+// if there is not one value for each label.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for labels %v", len(values), v.labels))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (v *vec) get(values []string, create func() interface{}) interface{} {
+	k := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = make(map[string]interface{})
+	}
+	m, ok := v.values[k]
+	if !ok {
+		m = create()
+		v.values[k] = m
+	}
+	return m
+}
+
+// each calls fn for each combination of label values in order.
+func (v *vec) each(fn func(labels string, m interface{})) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pairs := make([]string, len(v.labels))
+		for i, value := range strings.Split(k, "\xff") {
+			pairs[i] = fmt.Sprintf("%s=\"%s\"", v.labels[i], labelEscaper.Replace(value))
+		}
+		fn(strings.Join(pairs, ","), v.values[k])
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	name, help string
+
+	mu sync.Mutex
+	v  float64
+}
+
+// NewCounter creates and registers a counter.
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v to the counter.
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	c.v += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.v
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.Value()))
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	name, help string
+	vec
+}
+
+// NewCounterVec creates and registers a counter partitioned by labels.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, vec: vec{labels: labels}}
+	register(c)
+	return c
+}
+
+// With returns the counter for the values of the labels.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.each(func(labels string, m interface{}) {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, labels, formatFloat(m.(*Counter).Value()))
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	name, help string
+
+	mu sync.Mutex
+	v  float64
+}
+
+// NewGauge creates and registers a gauge.
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(g)
+	return g
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.v = v
+	g.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.Value()))
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	name, help string
+	vec
+}
+
+// NewGaugeVec creates and registers a gauge partitioned by labels.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, vec: vec{labels: labels}}
+	register(g)
+	return g
+}
+
+// With returns the gauge for the values of the labels.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	g.each(func(labels string, m interface{}) {
+		fmt.Fprintf(w, "%s{%s} %s\n", g.name, labels, formatFloat(m.(*Gauge).Value()))
+	})
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	name, help string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// NewHistogram creates and registers a histogram with the buckets.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	h.name, h.help = name, help
+	register(h)
+	return h
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.writeSamples(w, h.name, "")
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) writeSamples(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	name, help string
+	buckets    []float64
+	vec
+}
+
+// NewHistogramVec creates and registers a histogram partitioned by label.
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, buckets: buckets, vec: vec{labels: []string{label}}}
+	register(h)
+	return h
+}
+
+// With returns the histogram for the label value.
+func (h *HistogramVec) With(value string) *Histogram {
+	return h.get([]string{value}, func() interface{} { return newHistogram(h.buckets) }).(*Histogram)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.each(func(labels string, m interface{}) {
+		m.(*Histogram).writeSamples(w, h.name, labels)
+	})
+}
+
+// Rate is a gauge that measures how many events per second happen.
+// The rate is smoothed with an exponentially weighted moving average
+// and decays towards zero when no events happen.
+type Rate struct {
+	name, help string
+
+	mu   sync.Mutex
+	rate float64
+	last time.Time
+}
+
+// rateWindow is the time constant of the moving average.
+const rateWindow = 5 * time.Second
+
+// NewRate creates and registers a rate.
+func NewRate(name, help string) *Rate {
+	r := &Rate{name: name, help: help}
+	register(r)
+	return r
+}
+
+// Mark records that an event happened now.
+func (r *Rate) Mark() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if !r.last.IsZero() {
+		r.rate = decay(r.rate, now.Sub(r.last)) + 1/rateWindow.Seconds()
+	}
+	r.last = now
+}
+
+// Value returns the current rate in events per second.
+func (r *Rate) Value() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last.IsZero() {
+		return 0
+	}
+	return decay(r.rate, time.Since(r.last))
+}
+
+func decay(rate float64, elapsed time.Duration) float64 {
+	return rate * math.Exp(-elapsed.Seconds()/rateWindow.Seconds())
+}
+
+func (r *Rate) write(w io.Writer) {
+	writeHeader(w, r.name, r.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", r.name, formatFloat(r.Value()))
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+// isolate replaces the registered metrics with none until the test
+// ends, so only the metrics it creates are served.
+func isolate(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	saved := collectors
+	collectors = nil
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		collectors = saved
+		mu.Unlock()
+	})
+}
+
+// serve returns the body served by the handler.
+func serve(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got, want := rec.Header().Get("Content-Type"), "text/plain; version=0.0.4"; got != want {
+		t.Errorf("Content-Type = %q, want %q", got, want)
+	}
+	return rec.Body.String()
+}
+
+const golden = `# HELP test_counter_total Things counted.
+# TYPE test_counter_total counter
+test_counter_total 3
+# HELP test_errors_total Errors with a \\ backslash
+# TYPE test_errors_total counter
+test_errors_total{subsystem="a\"b"} 1
+test_errors_total{subsystem="line\nbreak"} 2
+test_errors_total{subsystem="servo"} 0.5
+# HELP test_gauge Gauge with a\nnew line.
+# TYPE test_gauge gauge
+test_gauge -Inf
+# HELP test_angle_degrees Angles.
+# TYPE test_angle_degrees gauge
+test_angle_degrees{axis="x"} 90
+test_angle_degrees{axis="y"} 1e-07
+# HELP test_servo_degrees Angles by turret.
+# TYPE test_servo_degrees gauge
+test_servo_degrees{turret="0",axis="x"} 1
+test_servo_degrees{turret="0",axis="y"} 2
+test_servo_degrees{turret="1",axis="x"} 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 3
+test_latency_seconds_bucket{le="+Inf"} 4
+test_latency_seconds_sum 6.75
+test_latency_seconds_count 4
+# HELP test_stage_seconds Stages.
+# TYPE test_stage_seconds histogram
+test_stage_seconds_bucket{stage="read",le="0.5"} 0
+test_stage_seconds_bucket{stage="read",le="+Inf"} 1
+test_stage_seconds_sum{stage="read"} 2
+test_stage_seconds_count{stage="read"} 1
+# HELP test_fps Rate.
+# TYPE test_fps gauge
+test_fps 0
+`
+
+func TestHandler(t *testing.T) {
+	isolate(t)
+	c := NewCounter("test_counter_total", "Things counted.")
+	c.Inc()
+	c.Add(2)
+	cv := NewCounterVec("test_errors_total", `Errors with a \ backslash`, "subsystem")
+	cv.With("servo").Add(0.5)
+	cv.With(`a"b`).Inc()
+	cv.With("line\nbreak").Add(2)
+	g := NewGauge("test_gauge", "Gauge with a\nnew line.")
+	g.Set(math.Inf(-1))
+	gv := NewGaugeVec("test_angle_degrees", "Angles.", "axis")
+	gv.With("y").Set(1e-7)
+	gv.With("x").Set(90)
+	sv := NewGaugeVec("test_servo_degrees", "Angles by turret.", "turret", "axis")
+	sv.With("1", "x").Set(3)
+	sv.With("0", "y").Set(2)
+	sv.With("0", "x").Set(1)
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	// the buckets are cumulative and the observations over the last
+	// one are only counted in +Inf.
+	for _, v := range []float64{0.05, 0.2, 1, 5.5} {
+		h.Observe(v)
+	}
+	hv := NewHistogramVec("test_stage_seconds", "Stages.", "stage", []float64{0.5})
+	hv.With("read").Observe(2)
+	NewRate("test_fps", "Rate.")
+
+	if got := serve(t); got != golden {
+		t.Errorf("Handler() served:\n%s\nwant:\n%s", got, golden)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{-2, "-2"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	isolate(t)
+	r := NewRate("test_rate", "Rate.")
+	if v := r.Value(); v != 0 {
+		t.Errorf("Value() before any event = %v, want 0", v)
+	}
+	for i := 0; i < 3; i++ {
+		r.Mark()
+	}
+	// the first event only starts the measure, each one after it adds
+	// 1/rateWindow to the rate.
+	if v, want := r.Value(), 2/rateWindow.Seconds(); math.Abs(v-want) > 0.01 {
+		t.Errorf("Value() = %v, want %v", v, want)
+	}
+}
+
+func TestWithWrongLabels(t *testing.T) {
+	isolate(t)
+	g := NewGaugeVec("test_wrong_labels", "Labels.", "turret", "axis")
+	defer func() {
+		if recover() == nil {
+			t.Error("With() with one value for two labels did not panic")
+		}
+	}()
+	g.With("x")
+}
@@ -0,0 +1,305 @@
+// Package power monitors the current drawn by the servos and the
+// voltage of their supply with an INA3221. Servos that stall or draw
+// too much current are backed off and disabled if they keep failing,
+// before they get hot or brown the raspberry out.
+package power
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/gobot/drivers/i2c"
+)
+
+// Sensor measures the bus voltage in volts and the current in
+// milliamps of each channel. i2c.INA3221Driver implements it.
+type Sensor interface {
+	GetBusVoltage(ch i2c.INA3221Channel) (float64, error)
+	GetCurrent(ch i2c.INA3221Channel) (float64, error)
+}
+
+var _ Sensor = (*i2c.INA3221Driver)(nil)
+
+// Turret is the turret whose servos are monitored. turret.Turret
+// implements it.
+type Turret interface {
+	Name() string
+	Position() (x, y float64)
+	MoveX(angle float64) error
+	MoveY(angle float64) error
+	Hold(axis string, d time.Duration) error
+	Disable(axis string) error
+	LastMove() time.Time
+}
+
+// Channel is the channel of the sensor a servo is powered through.
+type Channel struct {
+	// Axis is x for the pan servo and y for the tilt one.
+	Axis    string
+	Channel i2c.INA3221Channel
+}
+
+// Config configures a monitor.
+type Config struct {
+	Channels []Channel
+	// Interval is the time between two readings of the sensor.
+	Interval time.Duration
+	// MaxCurrent is the current in amps over which a servo is
+	// over-current at once.
+	MaxCurrent float64
+	// StallCurrent is the current in amps that a servo may only draw
+	// while it moves, a servo that draws more for StallTime stalled.
+	StallCurrent float64
+	StallTime    time.Duration
+	// Settle is the time the servos take to reach a commanded angle.
+	// An angle is only good once the servo drew a normal current for
+	// longer than it since the last command.
+	Settle time.Duration
+	// Cooldown is how long a servo ignores its commands after it is
+	// backed off.
+	Cooldown time.Duration
+	// MaxFaults is the number of faults within FaultWindow after
+	// which the servo is disabled.
+	MaxFaults   int
+	FaultWindow time.Duration
+	// MinVoltage is the supply voltage under which it is sagging.
+	// Zero disables the check.
+	MinVoltage float64
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Kinds of faults.
+const (
+	// Stall means the servo drew more than the stall current for
+	// longer than a movement takes.
+	Stall = "stall"
+	// OverCurrent means the servo drew more than the maximum current.
+	OverCurrent = "over-current"
+)
+
+// Fault is a servo that drew too much current.
+type Fault struct {
+	Axis string
+	Kind string
+	// Current is the current in amps and Voltage the supply voltage
+	// when the fault was detected.
+	Current, Voltage float64
+	// Disabled is true if the fault disabled the servo.
+	Disabled bool
+}
+
+// servo is the state of a monitored servo.
+type servo struct {
+	Channel
+	// good is the last angle the servo reached drawing a normal current.
+	good float64
+	// over is since when the servo draws more than the stall current,
+	// it is zero while it does not.
+	over     time.Time
+	faults   []time.Time
+	disabled bool
+}
+
+// Monitor reads the sensor periodically and protects the servos of
+// the turret.
+type Monitor struct {
+	sensor Sensor
+	turret Turret
+	cfg    Config
+
+	// servos and sagging are only used by the goroutine that reads
+	// the sensor.
+	servos  []*servo
+	sagging bool
+	lowest  float64
+
+	mu        sync.Mutex
+	listeners []func(Fault)
+
+	log  *slog.Logger
+	warn *slog.Logger
+}
+
+// NewMonitor creates a monitor of the servos of the turret that
+// reads the sensor.
+func NewMonitor(s Sensor, t Turret, cfg Config) *Monitor {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	m := &Monitor{
+		sensor: s,
+		turret: t,
+		cfg:    cfg,
+		log:    logger,
+		warn:   logging.Throttled(logger, 5*time.Second),
+	}
+	for _, c := range cfg.Channels {
+		sv := &servo{Channel: c}
+		sv.good = m.angle(c.Axis)
+		m.servos = append(m.servos, sv)
+	}
+	return m
+}
+
+// OnFault calls f with every fault detected. f is called with no
+// locks held.
+func (m *Monitor) OnFault(f func(Fault)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, f)
+}
+
+// Run reads the sensor every interval until the context is closed.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.sample(now)
+		}
+	}
+}
+
+// sample reads every channel once at now.
+func (m *Monitor) sample(now time.Time) {
+	lowest, read := 0.0, false
+	for _, sv := range m.servos {
+		v, err := m.sensor.GetBusVoltage(sv.Channel.Channel)
+		if err != nil {
+			m.readError(sv, err)
+			continue
+		}
+		ma, err := m.sensor.GetCurrent(sv.Channel.Channel)
+		if err != nil {
+			m.readError(sv, err)
+			continue
+		}
+		a := ma / 1000
+		metrics.ServoVoltage.With(m.turret.Name(), sv.Axis).Set(v)
+		metrics.ServoCurrent.With(m.turret.Name(), sv.Axis).Set(a)
+		if !read || v < lowest {
+			lowest, read = v, true
+		}
+		if f, ok := m.check(sv, now, a, v); ok {
+			m.fault(sv, now, f)
+		}
+	}
+	if read {
+		m.checkVoltage(lowest)
+	}
+}
+
+// readError records an error reading the channel of the servo.
+func (m *Monitor) readError(sv *servo, err error) {
+	metrics.Errors.With("power").Inc()
+	m.warn.Warn("Could not read the servo supply", "axis", sv.Axis, "err", err)
+}
+
+// check returns the fault of the servo that draws current a at now,
+// if there is one.
+func (m *Monitor) check(sv *servo, now time.Time, a, v float64) (Fault, bool) {
+	if sv.disabled {
+		return Fault{}, false
+	}
+	f := Fault{Axis: sv.Axis, Current: a, Voltage: v}
+	switch {
+	case a > m.cfg.MaxCurrent:
+		f.Kind = OverCurrent
+	case a > m.cfg.StallCurrent:
+		if sv.over.IsZero() {
+			sv.over = now
+		}
+		if now.Sub(sv.over) < m.cfg.StallTime {
+			return Fault{}, false
+		}
+		f.Kind = Stall
+	default:
+		sv.over = time.Time{}
+		// right after a command the servo has not started to draw the
+		// current of a stall yet, so the angle is only good once it
+		// had time to reach it.
+		if now.Sub(m.turret.LastMove()) >= m.cfg.Settle {
+			sv.good = m.angle(sv.Axis)
+		}
+		return Fault{}, false
+	}
+	sv.over = time.Time{}
+	return f, true
+}
+
+// fault backs the servo off to the last angle it reached drawing a
+// normal current, or disables it if it failed too many times.
+func (m *Monitor) fault(sv *servo, now time.Time, f Fault) {
+	recent := sv.faults[:0]
+	for _, at := range sv.faults {
+		if now.Sub(at) < m.cfg.FaultWindow {
+			recent = append(recent, at)
+		}
+	}
+	sv.faults = append(recent, now)
+	metrics.ServoCurrentFaults.With(m.turret.Name(), sv.Axis).Inc()
+
+	if len(sv.faults) >= m.cfg.MaxFaults {
+		sv.disabled, f.Disabled = true, true
+		m.log.Error("Servo disabled after repeated faults", "axis", sv.Axis, "fault", f.Kind, "current", f.Current, "voltage", f.Voltage, "faults", len(sv.faults))
+		if err := m.turret.Disable(sv.Axis); err != nil {
+			m.log.Error("Could not disable the servo", "axis", sv.Axis, "err", err)
+		}
+	} else {
+		m.log.Warn("Servo drew too much current, backing off", "axis", sv.Axis, "fault", f.Kind, "current", f.Current, "voltage", f.Voltage, "angle", sv.good, "cooldown", m.cfg.Cooldown)
+		move := m.turret.MoveX
+		if sv.Axis == "y" {
+			move = m.turret.MoveY
+		}
+		if err := move(sv.good); err != nil {
+			m.log.Warn("Could not back the servo off", "axis", sv.Axis, "err", err)
+		}
+		if err := m.turret.Hold(sv.Axis, m.cfg.Cooldown); err != nil {
+			m.log.Warn("Could not hold the servo", "axis", sv.Axis, "err", err)
+		}
+	}
+
+	m.mu.Lock()
+	listeners := m.listeners
+	m.mu.Unlock()
+	for _, l := range listeners {
+		l(f)
+	}
+}
+
+// checkVoltage logs when the lowest supply voltage of the servos
+// drops below the minimum and when it recovers.
+func (m *Monitor) checkVoltage(v float64) {
+	if m.cfg.MinVoltage <= 0 {
+		return
+	}
+	switch {
+	case v < m.cfg.MinVoltage && !m.sagging:
+		m.sagging, m.lowest = true, v
+		metrics.VoltageSags.Inc()
+		m.log.Warn("Servo supply voltage sagging", "voltage", v, "min_voltage", m.cfg.MinVoltage)
+	case v < m.lowest && m.sagging:
+		m.lowest = v
+	case v >= m.cfg.MinVoltage && m.sagging:
+		m.sagging = false
+		m.log.Info("Servo supply voltage recovered", "voltage", v, "lowest", m.lowest)
+	}
+}
+
+// angle returns the angle the servo of the axis was last commanded to.
+func (m *Monitor) angle(axis string) float64 {
+	x, y := m.turret.Position()
+	if axis == "y" {
+		return y
+	}
+	return x
+}
@@ -0,0 +1,177 @@
+package power
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matipan/gobot/drivers/i2c"
+)
+
+// fakeSensor returns the current and voltage it holds for every
+// channel.
+type fakeSensor struct {
+	ma, volts float64
+	err       error
+}
+
+func (s *fakeSensor) GetBusVoltage(i2c.INA3221Channel) (float64, error) {
+	return s.volts, s.err
+}
+
+func (s *fakeSensor) GetCurrent(i2c.INA3221Channel) (float64, error) {
+	return s.ma, s.err
+}
+
+// fakeTurret records what the monitor does with the pan servo.
+type fakeTurret struct {
+	x, y     float64
+	moved    time.Time
+	held     int
+	disabled []string
+}
+
+func (t *fakeTurret) Name() string             { return "0" }
+func (t *fakeTurret) Position() (x, y float64) { return t.x, t.y }
+func (t *fakeTurret) MoveX(a float64) error    { t.x = a; return nil }
+func (t *fakeTurret) MoveY(a float64) error    { t.y = a; return nil }
+func (t *fakeTurret) LastMove() time.Time      { return t.moved }
+
+func (t *fakeTurret) Hold(axis string, d time.Duration) error {
+	t.held++
+	return nil
+}
+
+func (t *fakeTurret) Disable(axis string) error {
+	t.disabled = append(t.disabled, axis)
+	return nil
+}
+
+func newTestMonitor(s Sensor, t Turret) *Monitor {
+	return NewMonitor(s, t, Config{
+		Channels:     []Channel{{Axis: "x", Channel: i2c.INA3221Channel1}},
+		MaxCurrent:   1.5,
+		StallCurrent: 0.8,
+		StallTime:    500 * time.Millisecond,
+		Settle:       300 * time.Millisecond,
+		Cooldown:     time.Second,
+		MaxFaults:    3,
+		FaultWindow:  time.Minute,
+		MinVoltage:   4.6,
+	})
+}
+
+func TestStallBacksOff(t *testing.T) {
+	s, tu := &fakeSensor{ma: 300, volts: 5}, &fakeTurret{x: 90}
+	m := newTestMonitor(s, tu)
+	var faults []Fault
+	m.OnFault(func(f Fault) { faults = append(faults, f) })
+
+	now := time.Unix(0, 0)
+	m.sample(now)
+	// the servo is commanded further and stalls.
+	tu.x, s.ma = 150, 1000
+	m.sample(now.Add(100 * time.Millisecond))
+	if len(faults) != 0 {
+		t.Fatalf("faults while moving = %+v, want none", faults)
+	}
+	m.sample(now.Add(700 * time.Millisecond))
+	if len(faults) != 1 || faults[0].Kind != Stall || faults[0].Current != 1 || faults[0].Disabled {
+		t.Fatalf("faults = %+v, want a stall", faults)
+	}
+	if tu.x != 90 || tu.held != 1 {
+		t.Errorf("turret at %g held %d times, want backed off to 90 and held once", tu.x, tu.held)
+	}
+}
+
+func TestMoveIntoStall(t *testing.T) {
+	s, tu := &fakeSensor{ma: 300, volts: 5}, &fakeTurret{x: 90}
+	m := newTestMonitor(s, tu)
+
+	now := time.Unix(0, 0)
+	m.sample(now)
+	// the servo is commanded into an obstacle, it still draws a
+	// normal current right after the command and stalls once it
+	// reaches the obstacle.
+	tu.x, tu.moved = 150, now.Add(time.Second)
+	m.sample(now.Add(time.Second + 20*time.Millisecond))
+	s.ma = 1000
+	m.sample(now.Add(time.Second + 200*time.Millisecond))
+	m.sample(now.Add(time.Second + 800*time.Millisecond))
+	if tu.x != 90 || tu.held != 1 {
+		t.Errorf("turret at %g held %d times, want backed off to 90 and held once", tu.x, tu.held)
+	}
+}
+
+func TestSettledAngleIsGood(t *testing.T) {
+	s, tu := &fakeSensor{ma: 300, volts: 5}, &fakeTurret{x: 90}
+	m := newTestMonitor(s, tu)
+
+	now := time.Unix(0, 0)
+	tu.x, tu.moved = 120, now
+	m.sample(now.Add(400 * time.Millisecond))
+	// the servo reached 120 before stalling on the next command.
+	tu.x, tu.moved, s.ma = 150, now.Add(time.Second), 2000
+	m.sample(now.Add(time.Second + 20*time.Millisecond))
+	if tu.x != 120 {
+		t.Errorf("turret backed off to %g, want 120", tu.x)
+	}
+}
+
+func TestOverCurrentDisables(t *testing.T) {
+	s, tu := &fakeSensor{ma: 2000, volts: 5}, &fakeTurret{x: 90}
+	m := newTestMonitor(s, tu)
+	var faults []Fault
+	m.OnFault(func(f Fault) { faults = append(faults, f) })
+
+	now := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		m.sample(now.Add(time.Duration(i) * time.Second))
+	}
+	// the third fault disables the servo, which is no longer checked.
+	if len(faults) != 3 || faults[0].Kind != OverCurrent || !faults[2].Disabled {
+		t.Fatalf("faults = %+v, want 3 over-currents, the last one disabling the servo", faults)
+	}
+	if len(tu.disabled) != 1 || tu.disabled[0] != "x" {
+		t.Errorf("disabled = %v, want [x]", tu.disabled)
+	}
+}
+
+func TestFaultsExpire(t *testing.T) {
+	s, tu := &fakeSensor{ma: 2000, volts: 5}, &fakeTurret{x: 90}
+	m := newTestMonitor(s, tu)
+
+	now := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		m.sample(now.Add(time.Duration(i) * time.Minute))
+	}
+	if len(tu.disabled) != 0 || tu.held != 5 {
+		t.Errorf("disabled = %v held %d times, want none disabled and held 5 times", tu.disabled, tu.held)
+	}
+}
+
+func TestVoltageSag(t *testing.T) {
+	s, tu := &fakeSensor{ma: 300, volts: 5}, &fakeTurret{}
+	m := newTestMonitor(s, tu)
+
+	now := time.Unix(0, 0)
+	for _, v := range []float64{5, 4.5, 4.2, 4.4, 4.8} {
+		s.volts = v
+		m.sample(now)
+		if want := v < 4.6; m.sagging != want {
+			t.Errorf("sagging at %vV = %v, want %v", v, m.sagging, want)
+		}
+	}
+	if m.lowest != 4.2 {
+		t.Errorf("lowest = %v, want 4.2", m.lowest)
+	}
+}
+
+func TestReadError(t *testing.T) {
+	s, tu := &fakeSensor{err: errors.New("i2c")}, &fakeTurret{}
+	m := newTestMonitor(s, tu)
+	m.sample(time.Unix(0, 0))
+	if m.sagging || tu.held != 0 {
+		t.Error("a failed reading was used")
+	}
+}
@@ -0,0 +1,118 @@
+// Package rangefinder reads the distance to the targets from a
+// rangefinder mounted on the turret, such as a LIDAR-Lite.
+package rangefinder
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/gobot/drivers/i2c"
+)
+
+// Sensor measures the distance in centimeters to what the turret
+// aims at. i2c.LIDARLiteDriver implements it.
+type Sensor interface {
+	Distance() (int, error)
+}
+
+var _ Sensor = (*i2c.LIDARLiteDriver)(nil)
+
+// Config configures a rangefinder.
+type Config struct {
+	// Interval is the time between two readings.
+	Interval time.Duration
+	// MaxAge is how long a reading is used, after it the distance
+	// is unknown until the next successful reading.
+	MaxAge time.Duration
+	// Min and Max are the distances in meters that the sensor can
+	// measure, readings outside of them are discarded.
+	Min, Max float64
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Rangefinder reads a sensor periodically and keeps the last
+// distance it measured.
+type Rangefinder struct {
+	sensor Sensor
+	cfg    Config
+
+	mu       sync.Mutex
+	distance float64
+	at       time.Time
+
+	warn *slog.Logger
+}
+
+// New creates a rangefinder that reads from the sensor.
+func New(s Sensor, cfg Config) *Rangefinder {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	return &Rangefinder{
+		sensor: s,
+		cfg:    cfg,
+		warn:   logging.Throttled(logger, 5*time.Second),
+	}
+}
+
+// Run reads the sensor every interval until the context is closed.
+func (r *Rangefinder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.read()
+		}
+	}
+}
+
+// read reads the sensor once and keeps the distance if it is valid.
+func (r *Rangefinder) read() {
+	cm, err := r.sensor.Distance()
+	if err != nil {
+		metrics.Errors.With("rangefinder").Inc()
+		r.warn.Warn("Could not read the rangefinder", "err", err)
+		return
+	}
+	d := float64(cm) / 100
+	if d < r.cfg.Min || (r.cfg.Max > 0 && d > r.cfg.Max) {
+		r.warn.Warn("Rangefinder reading out of range", "distance", d, "min", r.cfg.Min, "max", r.cfg.Max)
+		return
+	}
+	metrics.Range.Set(d)
+	r.mu.Lock()
+	r.distance, r.at = d, time.Now()
+	r.mu.Unlock()
+}
+
+// Distance returns the last distance measured in meters. ok is false
+// if there is no reading newer than MaxAge.
+func (r *Rangefinder) Distance() (distance float64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.at.IsZero() || time.Since(r.at) > r.cfg.MaxAge {
+		return 0, false
+	}
+	return r.distance, true
+}
+
+// Attach returns a handler that sets the range of the detections to
+// the last distance measured, if there is one, before calling h.
+func (r *Rangefinder) Attach(h func(event.Detection)) func(event.Detection) {
+	return func(d event.Detection) {
+		if dist, ok := r.Distance(); ok {
+			d.Range = dist
+		}
+		h(d)
+	}
+}
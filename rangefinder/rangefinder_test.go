@@ -0,0 +1,69 @@
+package rangefinder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+// fakeSensor returns the distance or the error it holds.
+type fakeSensor struct {
+	cm  int
+	err error
+}
+
+func (s *fakeSensor) Distance() (int, error) {
+	return s.cm, s.err
+}
+
+func TestDistance(t *testing.T) {
+	s := &fakeSensor{cm: 250}
+	r := New(s, Config{MaxAge: time.Hour, Min: 0.1, Max: 10})
+	if _, ok := r.Distance(); ok {
+		t.Error("Distance() before reading ok = true")
+	}
+
+	r.read()
+	if d, ok := r.Distance(); !ok || d != 2.5 {
+		t.Errorf("Distance() = (%v, %v), want (2.5, true)", d, ok)
+	}
+
+	// failed and out of range readings keep the last distance.
+	s.err = errors.New("i2c")
+	r.read()
+	s.cm, s.err = 5, nil
+	r.read()
+	s.cm = 4000
+	r.read()
+	if d, ok := r.Distance(); !ok || d != 2.5 {
+		t.Errorf("Distance() after bad readings = (%v, %v), want (2.5, true)", d, ok)
+	}
+}
+
+func TestDistanceExpires(t *testing.T) {
+	r := New(&fakeSensor{cm: 100}, Config{MaxAge: time.Millisecond})
+	r.read()
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := r.Distance(); ok {
+		t.Error("Distance() of an old reading ok = true")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	r := New(&fakeSensor{cm: 320}, Config{MaxAge: time.Hour})
+	var got event.Detection
+	h := r.Attach(func(d event.Detection) { got = d })
+
+	h(event.Detection{Area: 1})
+	if got.Range != 0 || got.Area != 1 {
+		t.Errorf("Attach() without reading passed %+v, want no range", got)
+	}
+
+	r.read()
+	h(event.Detection{Area: 2})
+	if got.Range != 3.2 || got.Area != 2 {
+		t.Errorf("Attach() passed %+v, want range 3.2", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/hud"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/dartagnan/window"
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+func replayCmd(args []string) error {
+	var (
+		tf turretFlags
+		df detectorFlags
+		hf hudFlags
+		cf captureFlags
+		lf logFlags
+	)
+	fs := newFlagSet("replay", "[flags] <file>", "Replays a recorded video file through the detector, showing the windows\nas if it was the camera. Optionally the turret is driven as well.")
+	area := fs.Float64("area", minArea, "base area for motion detection")
+	fps := fs.Float64("fps", 0, "frames per second of the replay, 0 uses the FPS of the file")
+	aim := fs.Bool("turret", false, "aim the turret at the detected motion")
+	tf.register(fs)
+	df.register(fs)
+	hf.register(fs)
+	cf.register(fs, "all")
+	lf.register(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a video file is required")
+	}
+
+	logs, err := lf.loggers()
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	video, err := gocv.VideoCaptureFile(fs.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "Could not open video file")
+	}
+	if *fps <= 0 {
+		*fps = video.Get(gocv.VideoCaptureFPS)
+	}
+	var ts []*turret.Turret
+	handler := func(event.Detection) {}
+	if *aim {
+		tf.lens = df.lens
+		ts, err = tf.turrets(logs.For("turret"))
+		if err != nil {
+			return err
+		}
+		handler = detector.Handlers(handlers(ts, nil, nil, 0)...)
+	}
+
+	h, err := hf.hud(hud.Sources{Turrets: ts, Lead: tf.lead})
+	if err != nil {
+		return err
+	}
+	wm := window.New(800, 600)
+	defer wm.Close()
+	streamer, closeRecorder, err := hf.streamer(wm)
+	if err != nil {
+		return err
+	}
+	defer closeRecorder()
+	src, err := cf.source(&pacedSource{FrameSource: video, fps: *fps})
+	if err != nil {
+		video.Close()
+		return err
+	}
+	d, err := detector.New(src, *area, handler, streamer, logs.For("detector"))
+	if err != nil {
+		return err
+	}
+	if err := df.apply(d); err != nil {
+		d.Close()
+		return err
+	}
+	d.AddOverlay(h.Overlay)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c
+		cancel()
+	}()
+	return ignoreEOF(d.Run(ctx))
+}
+
+// pacedSource limits the rate at which frames are read from
+// the source to fps. If fps is not positive frames are read
+// as fast as possible.
+type pacedSource struct {
+	detector.FrameSource
+	fps  float64
+	last time.Time
+}
+
+func (p *pacedSource) Read(m *gocv.Mat) bool {
+	if p.fps > 0 {
+		if wait := time.Duration(float64(time.Second)/p.fps) - time.Since(p.last); wait > 0 {
+			time.Sleep(wait)
+		}
+		p.last = time.Now()
+	}
+	return p.FrameSource.Read(m)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"image"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/hud"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/dartagnan/trigger"
+	"github.com/matipan/dartagnan/window"
+	"github.com/pkg/errors"
+)
+
+func runCmd(args []string) error {
+	var (
+		tf turretFlags
+		gf triggerFlags
+		pf patrolFlags
+		df detectorFlags
+		sf targetFlags
+		hf hudFlags
+		cf captureFlags
+		vf cameraFlags
+		rf rangeFlags
+		wf wakeFlags
+		mf imuFlags
+		pw powerFlags
+		lf logFlags
+	)
+	fs := newFlagSet("run", "[flags]", "Tracks motion with the camera and aims the turrets at it.")
+	area := fs.Float64("area", minArea, "base area for motion detection")
+	device := fs.Int("device", 0, "device ID for the camera")
+	metricsAddr := fs.String("metrics", ":2112", "address where the /metrics endpoint is served, empty disables it")
+	controlAddr := fs.String("control", "", "address where the unauthenticated /control endpoints are served, for example 127.0.0.1:2113, empty disables them")
+	restarts := fs.Int("restarts", 0, "number of failed camera reconnections in a row before shutting down, 0 never gives up")
+	servoErrors := fs.Int("servo-errors", 10, "number of servo errors in a row after which the turret shuts down")
+	stall := fs.Duration("stall", 3*time.Second, "time without frames after which the camera is considered stalled")
+	minBackoff := fs.Duration("min-backoff", 500*time.Millisecond, "initial time between camera reconnections")
+	maxBackoff := fs.Duration("max-backoff", 30*time.Second, "maximum time between camera reconnections")
+	parkX := fs.Float64("park-x", 90, "angle of the servo in the X axis while the camera is down")
+	parkY := fs.Float64("park-y", 0, "angle of the servo in the Y axis while the camera is down")
+	cameraTurret := fs.Int("camera-turret", -1, "index of the turret the camera is mounted on, -1 if the camera is fixed")
+	settle := fs.Duration("settle", 300*time.Millisecond, "time the servos take to stop, detection is suppressed meanwhile when the camera is on a turret")
+	laserColor := fs.String("laser-color", "", "color of the laser dot to look for in the frames (red or green), empty disables it")
+	laserArea := fs.Float64("laser-area", 400, "maximum area in pixels of the laser dot")
+	tf.register(fs)
+	gf.register(fs)
+	pf.register(fs)
+	df.register(fs)
+	sf.register(fs)
+	hf.register(fs)
+	cf.register(fs, "latest")
+	vf.register(fs)
+	rf.register(fs)
+	wf.register(fs)
+	mf.register(fs)
+	pw.register(fs)
+	lf.register(fs)
+	fs.Parse(args)
+	if *parkX < 0 || *parkX > 180 || *parkY < 0 || *parkY > 180 {
+		return errors.New("park angles must be between 0 and 180")
+	}
+	var laserRanges []detector.HSVRange
+	if *laserColor != "" {
+		var err error
+		if laserRanges, err = detector.LaserColor(*laserColor); err != nil {
+			return err
+		}
+	}
+
+	logs, err := lf.loggers()
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	tf.lens = df.lens
+	ts, err := tf.turrets(logs.For("turret"))
+	if err != nil {
+		return err
+	}
+	ps, err := pf.patrols(ts)
+	if err != nil {
+		return err
+	}
+	if *cameraTurret >= len(ts) {
+		return errors.Errorf("-camera-turret %d does not exist, there are %d turrets", *cameraTurret, len(ts))
+	}
+	ranger, err := rf.rangefinder(logs.For("rangefinder"))
+	if err != nil {
+		return err
+	}
+	wk, err := wf.wake(logs.For("pir"))
+	if err != nil {
+		return err
+	}
+	monitor, err := mf.monitor(ts, logs.For("imu"))
+	if err != nil {
+		return err
+	}
+	supply, err := pw.monitor(ts, logs.For("power"))
+	if err != nil {
+		return err
+	}
+	tr, err := gf.trigger(ts, logs.For("trigger"))
+	if err != nil {
+		return err
+	}
+	if tr != nil {
+		defer tr.Disarm()
+		go armOnSignal(tr, logs.For("trigger"))
+	}
+	hs := handlers(ts, ps, tr, gf.turret)
+	aim := func() image.Point {
+		x, y := ts[0].Position()
+		return ts[0].Pixel(x, y)
+	}
+	if *cameraTurret >= 0 {
+		// the turret always aims at the middle of the image.
+		aim = func() image.Point { return image.Pt(imgSize/2, imgSize/2) }
+	}
+	sel, err := sf.selector(aim, logs.For("target"))
+	if err != nil {
+		return err
+	}
+	h, err := hf.hud(hud.Sources{
+		Turrets:  ts,
+		Patrols:  ps,
+		Selector: sel,
+		Trigger:  tr,
+		Lead:     tf.lead,
+		OnTurret: *cameraTurret >= 0,
+		Wake:     wk,
+		IMU:      monitor,
+	})
+	if err != nil {
+		return err
+	}
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			logs.For("metrics").Error("Metrics server stopped", "err", http.ListenAndServe(*metricsAddr, mux))
+		}()
+	}
+	if *controlAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/control/", controlHandler(sel, tr, h))
+		go func() {
+			logs.For("control").Error("Control server stopped", "err", http.ListenAndServe(*controlAddr, mux))
+		}()
+	}
+	wm := window.New(800, 600)
+	defer wm.Close()
+	streamer, closeRecorder, err := hf.streamer(wm)
+	if err != nil {
+		return err
+	}
+	defer closeRecorder()
+	newDetector := func() (*detector.Detector, error) {
+		camera, err := vf.open(*device, logs.For("camera"))
+		if err != nil {
+			return nil, err
+		}
+		video, err := cf.source(camera)
+		if err != nil {
+			camera.Close()
+			return nil, err
+		}
+		handler := detector.Handlers(hs...)
+		if ranger != nil {
+			handler = ranger.Attach(handler)
+		}
+		d, err := detector.New(video, *area, handler, streamer, logs.For("detector"))
+		if err != nil {
+			video.Close()
+			return nil, err
+		}
+		if err := df.apply(d); err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.SetSelector(sel)
+		d.AddOverlay(h.Overlay)
+		if laserRanges != nil {
+			d.SetLaser(detector.NewLaser(laserRanges, *laserArea))
+		}
+		if *cameraTurret >= 0 {
+			d.SetMount(ts[*cameraTurret], *settle)
+		}
+		if wk != nil {
+			d.SetThrottle(wk)
+			// the camera is not read faster than the frames are
+			// processed while the pipeline sleeps.
+			if c, ok := video.(*detector.Capture); ok {
+				c.SetWaker(wk)
+			}
+		}
+		return d, nil
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if ranger != nil {
+		go ranger.Run(ctx)
+	}
+	if monitor != nil {
+		go monitor.Run(ctx)
+	}
+	if supply != nil {
+		go supply.Run(ctx)
+	}
+	s := &supervisor{
+		newDetector:    newDetector,
+		turrets:        ts,
+		patrols:        ps,
+		restarts:       *restarts,
+		maxServoErrors: *servoErrors,
+		stall:          *stall,
+		minBackoff:     *minBackoff,
+		maxBackoff:     *maxBackoff,
+		parkX:          *parkX,
+		parkY:          *parkY,
+		log:            logs.For("supervisor"),
+	}
+	return s.run(ctx)
+}
+
+// armOnSignal arms the trigger on SIGUSR1 and disarms it on SIGUSR2.
+func armOnSignal(tr *trigger.Trigger, log *slog.Logger) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range c {
+		var err error
+		if sig == syscall.SIGUSR1 {
+			err = tr.Arm()
+		} else {
+			err = tr.Disarm()
+		}
+		if err != nil {
+			log.Error("Could not change the trigger state", "signal", sig, "err", err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func servoCmd(args []string) error {
+	if len(args) < 1 {
+		servoUsage()
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "set":
+		return servoSetCmd(args[1:])
+	case "sweep":
+		return servoSweepCmd(args[1:])
+	}
+	servoUsage()
+	os.Exit(2)
+	return nil
+}
+
+func servoUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s servo <set|sweep> [flags]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  set    move the servos to a fixed angle\n")
+	fmt.Fprintf(os.Stderr, "  sweep  sweep a servo back and forth across a range of angles\n")
+}
+
+func servoSetCmd(args []string) error {
+	var tf turretFlags
+	fs := newFlagSet("servo set", "[flags]", "Moves the servos to the given angles.")
+	x := fs.Float64("x", 90, "angle of the servo in the X axis")
+	y := fs.Float64("y", 90, "angle of the servo in the Y axis")
+	tf.register(fs)
+	fs.Parse(args)
+
+	if *x < 0 || *x > 180 || *y < 0 || *y > 180 {
+		return errors.New("angles must be between 0 and 180")
+	}
+	ts, err := tf.turrets(nil)
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if err := t.MoveX(*x); err != nil {
+			return err
+		}
+		if err := t.MoveY(*y); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func servoSweepCmd(args []string) error {
+	var tf turretFlags
+	fs := newFlagSet("servo sweep", "[flags]", "Sweeps a servo from one angle to another and back.")
+	axis := fs.String("axis", "x", "axis of the servo to sweep (x or y)")
+	from := fs.Float64("from", 0, "angle where the sweep starts")
+	to := fs.Float64("to", 180, "angle where the sweep ends")
+	step := fs.Float64("step", 5, "degrees moved on each step")
+	delay := fs.Duration("delay", 50*time.Millisecond, "time to wait between each step")
+	times := fs.Int("times", 1, "number of sweeps to perform")
+	tf.register(fs)
+	fs.Parse(args)
+
+	if *from < 0 || *to > 180 || *from >= *to {
+		return errors.New("from and to must be between 0 and 180 and from must be lower than to")
+	}
+	if *step <= 0 {
+		return errors.New("step must be greater than 0")
+	}
+	if *axis != "x" && *axis != "y" {
+		return errors.Errorf("unknown axis %q", *axis)
+	}
+	ts, err := tf.turrets(nil)
+	if err != nil {
+		return err
+	}
+	move := func(angle float64) error {
+		for _, t := range ts {
+			m := t.MoveX
+			if *axis == "y" {
+				m = t.MoveY
+			}
+			if err := m(angle); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := 0; i < *times; i++ {
+		for a := *from; a <= *to; a += *step {
+			if err := sweepStep(move, a, *delay); err != nil {
+				return err
+			}
+		}
+		for a := *to; a >= *from; a -= *step {
+			if err := sweepStep(move, a, *delay); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sweepStep(move func(float64) error, angle float64, delay time.Duration) error {
+	if err := move(angle); err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	return nil
+}
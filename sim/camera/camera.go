@@ -0,0 +1,108 @@
+// Package camera renders the targets of the simulation as the frames
+// of a synthetic camera.
+package camera
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/matipan/dartagnan/sim"
+	"gocv.io/x/gocv"
+)
+
+// Camera is a synthetic camera that implements detector.FrameSource.
+// It renders the targets over a static background at a fixed frame
+// rate. The first frame only has the background so the detector can
+// use it as its reference.
+type Camera struct {
+	width, height int
+	fps           float64
+	duration      time.Duration
+
+	background gocv.Mat
+	targets    []sim.Target
+
+	start time.Time
+	last  time.Time
+	// OnFrame, if set, is called after each frame is rendered with
+	// the time since the first frame and the targets in the frame.
+	OnFrame func(elapsed time.Duration, targets []sim.Target)
+}
+
+var (
+	backgroundColor = gocv.NewScalar(90, 110, 100, 0)
+	propColors      = []color.RGBA{
+		{R: 60, G: 60, B: 160},
+		{R: 140, G: 120, B: 70},
+		{R: 40, G: 90, B: 40},
+	}
+)
+
+// New creates a camera of the given size that renders the targets at
+// fps frames per second for duration. If duration is 0 the camera
+// never stops.
+func New(width, height int, fps float64, duration time.Duration, targets []sim.Target) *Camera {
+	bg := gocv.NewMatWithSizeFromScalar(backgroundColor, height, width, gocv.MatTypeCV8UC3)
+	// a few static props so the background is not uniform.
+	for i, c := range propColors {
+		x, y := width*(i+1)/(len(propColors)+1), height*2/3
+		gocv.Rectangle(&bg, image.Rect(x-width/12, y-height/8, x+width/12, y+height/8), c, -1)
+	}
+	gocv.Line(&bg, image.Pt(0, height*4/5), image.Pt(width, height*4/5), color.RGBA{R: 30, G: 30, B: 30}, 3)
+	return &Camera{
+		width:      width,
+		height:     height,
+		fps:        fps,
+		duration:   duration,
+		background: bg,
+		targets:    append([]sim.Target(nil), targets...),
+	}
+}
+
+// Size returns the size of the frames.
+func (c *Camera) Size() image.Point {
+	return image.Pt(c.width, c.height)
+}
+
+// Read renders the next frame into m. It blocks until it is time
+// for the next frame and returns false once the duration elapsed.
+func (c *Camera) Read(m *gocv.Mat) bool {
+	now := time.Now()
+	if c.start.IsZero() {
+		c.start, c.last = now, now
+		c.background.CopyTo(m)
+		return true
+	}
+	if wait := time.Duration(float64(time.Second)/c.fps) - now.Sub(c.last); wait > 0 {
+		time.Sleep(wait)
+		now = time.Now()
+	}
+	elapsed := now.Sub(c.start)
+	if c.duration > 0 && elapsed > c.duration {
+		return false
+	}
+	c.move(now.Sub(c.last).Seconds())
+	c.last = now
+
+	c.background.CopyTo(m)
+	for _, t := range c.targets {
+		gocv.Circle(m, t.Point(), t.Radius, t.Color, -1)
+	}
+	if c.OnFrame != nil {
+		c.OnFrame(elapsed, append([]sim.Target(nil), c.targets...))
+	}
+	return true
+}
+
+// move moves the targets dt seconds.
+func (c *Camera) move(dt float64) {
+	for i := range c.targets {
+		c.targets[i].Step(dt, c.Size())
+	}
+}
+
+// Close closes the camera.
+func (c *Camera) Close() error {
+	return c.background.Close()
+}
@@ -0,0 +1,139 @@
+package sim
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Sample is the aiming error at a point in time.
+type Sample struct {
+	Elapsed time.Duration
+	// Target is the pixel the turret should be aiming at.
+	Target image.Point
+	// WantX and WantY are the angles needed to aim at the target.
+	WantX, WantY float64
+	// GotX and GotY are the angles the servos are at.
+	GotX, GotY float64
+	// Error is the distance in degrees between both.
+	Error float64
+}
+
+// Scorer measures how far the turret aims from the target.
+type Scorer struct {
+	turret *Turret
+	// aim returns the angles needed to aim at a pixel of the
+	// processed image.
+	aim func(image.Point) (x, y float64)
+	// frame is the size of the camera frames and img the size
+	// of the processed image.
+	frame image.Point
+	img   int
+
+	samples []Sample
+}
+
+// NewScorer creates a scorer for the turret. aim is the function used
+// to convert pixels of the processed image of size imgSize into angles,
+// it is usually turret.Turret.Angles. frame is the size of the camera
+// frames.
+func NewScorer(t *Turret, aim func(image.Point) (x, y float64), frame image.Point, imgSize int) *Scorer {
+	return &Scorer{turret: t, aim: aim, frame: frame, img: imgSize}
+}
+
+// Record records the aiming error for the first target. It can
+// be used as the OnFrame function of the camera.
+func (s *Scorer) Record(elapsed time.Duration, targets []Target) {
+	if len(targets) == 0 {
+		return
+	}
+	p := targets[0].Point()
+	// the detector mirrors the frames and resizes them to the
+	// processed image size.
+	p = image.Pt((s.frame.X-1-p.X)*s.img/s.frame.X, p.Y*s.img/s.frame.Y)
+	wx, wy := s.aim(p)
+	gx, gy := s.turret.Aim()
+	s.samples = append(s.samples, Sample{
+		Elapsed: elapsed,
+		Target:  p,
+		WantX:   wx,
+		WantY:   wy,
+		GotX:    gx,
+		GotY:    gy,
+		Error:   math.Hypot(wx-gx, wy-gy),
+	})
+}
+
+// Samples returns the recorded samples.
+func (s *Scorer) Samples() []Sample {
+	return s.samples
+}
+
+// Report summarizes the aiming error of the samples.
+type Report struct {
+	Samples  int
+	Commands int
+	// Mean, RMS, P95 and Max are the aiming errors in degrees.
+	Mean, RMS, P95, Max float64
+	// Timeline is the mean error of each second.
+	Timeline []float64
+}
+
+// Report creates the report of the recorded samples.
+func (s *Scorer) Report() Report {
+	r := Report{
+		Samples:  len(s.samples),
+		Commands: len(s.turret.Pan.Commands()) + len(s.turret.Tilt.Commands()),
+	}
+	if len(s.samples) == 0 {
+		return r
+	}
+	errs := make([]float64, len(s.samples))
+	var sum, sq float64
+	counts := []int{}
+	for i, sm := range s.samples {
+		errs[i] = sm.Error
+		sum += sm.Error
+		sq += sm.Error * sm.Error
+		r.Max = math.Max(r.Max, sm.Error)
+		sec := int(sm.Elapsed / time.Second)
+		for len(r.Timeline) <= sec {
+			r.Timeline = append(r.Timeline, 0)
+			counts = append(counts, 0)
+		}
+		r.Timeline[sec] += sm.Error
+		counts[sec]++
+	}
+	for i := range r.Timeline {
+		if counts[i] > 0 {
+			r.Timeline[i] /= float64(counts[i])
+		}
+	}
+	n := float64(len(errs))
+	r.Mean = sum / n
+	r.RMS = math.Sqrt(sq / n)
+	sort.Float64s(errs)
+	r.P95 = errs[int(math.Ceil(0.95*n))-1]
+	return r
+}
+
+// WriteTo writes the report in a human readable format.
+func (r Report) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "samples: %d\nservo commands: %d\naiming error (degrees): mean=%.2f rms=%.2f p95=%.2f max=%.2f\n",
+		r.Samples, r.Commands, r.Mean, r.RMS, r.P95, r.Max)
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+	for sec, e := range r.Timeline {
+		n, err = fmt.Fprintf(w, "  %3ds %6.2f\n", sec, e)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
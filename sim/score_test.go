@@ -0,0 +1,120 @@
+package sim
+
+import (
+	"image"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	st := NewTurret(100, 0)
+	s := NewScorer(st, nil, image.Pt(500, 500), 500)
+	if r := s.Report(); r.Samples != 0 || r.Mean != 0 || r.Timeline != nil {
+		t.Errorf("Report() without samples = %+v, want an empty report", r)
+	}
+
+	for _, sm := range []struct {
+		at  time.Duration
+		err float64
+	}{
+		{100 * time.Millisecond, 4},
+		{500 * time.Millisecond, 2},
+		{1200 * time.Millisecond, 1},
+		{2800 * time.Millisecond, 3},
+	} {
+		s.samples = append(s.samples, Sample{Elapsed: sm.at, Error: sm.err})
+	}
+	command(t, st.Pan, 10)
+	r := s.Report()
+
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"Mean", r.Mean, 2.5},
+		{"RMS", r.RMS, math.Sqrt(30.0 / 4)},
+		{"P95", r.P95, 4},
+		{"Max", r.Max, 4},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if r.Samples != 4 || r.Commands != 1 {
+		t.Errorf("Samples, Commands = %d, %d, want 4, 1", r.Samples, r.Commands)
+	}
+	// the second without samples has no error.
+	want := []float64{3, 1, 3}
+	if len(r.Timeline) != len(want) {
+		t.Fatalf("Timeline = %v, want %v", r.Timeline, want)
+	}
+	for i := range want {
+		if r.Timeline[i] != want[i] {
+			t.Errorf("Timeline = %v, want %v", r.Timeline, want)
+			break
+		}
+	}
+	var b strings.Builder
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), "mean=2.50 rms=2.74 p95=4.00 max=4.00") {
+		t.Errorf("WriteTo() wrote %q", b.String())
+	}
+}
+
+func TestReportP95(t *testing.T) {
+	s := NewScorer(NewTurret(100, 0), nil, image.Pt(500, 500), 500)
+	for i := 1; i <= 100; i++ {
+		s.samples = append(s.samples, Sample{Error: float64(i)})
+	}
+	if r := s.Report(); r.P95 != 95 || r.Max != 100 || r.Mean != 50.5 {
+		t.Errorf("Report() = P95 %v, Max %v, Mean %v, want 95, 100, 50.5", r.P95, r.Max, r.Mean)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	st := NewTurret(100, 0)
+	var aimed image.Point
+	aim := func(p image.Point) (x, y float64) {
+		aimed = p
+		return 30, 40
+	}
+	s := NewScorer(st, aim, image.Pt(640, 480), 500)
+	s.Record(time.Second, nil)
+	if len(s.Samples()) != 0 {
+		t.Fatal("Record() without targets recorded a sample")
+	}
+
+	s.Record(time.Second, []Target{{X: 100, Y: 240}, {X: 5, Y: 5}})
+	// the frame is mirrored and resized to the processed image.
+	if want := image.Pt(421, 250); aimed != want {
+		t.Errorf("aimed at %v, want %v", aimed, want)
+	}
+	samples := s.Samples()
+	if len(samples) != 1 {
+		t.Fatalf("len(Samples()) = %d, want 1", len(samples))
+	}
+	if sm := samples[0]; sm.Error != 50 || sm.GotX != 0 || sm.WantY != 40 {
+		t.Errorf("sample = %+v, want an error of 50 degrees from (0, 0)", sm)
+	}
+}
+
+func TestTargetStep(t *testing.T) {
+	size := image.Pt(100, 100)
+	tests := []struct {
+		from, want Target
+	}{
+		{Target{X: 50, Y: 50, VX: 10, VY: -20, Radius: 5}, Target{X: 60, Y: 30, VX: 10, VY: -20, Radius: 5}},
+		// the target bounces off the right border and the top one.
+		{Target{X: 90, Y: 10, VX: 10, VY: -10, Radius: 5}, Target{X: 90, Y: 10, VX: -10, VY: 10, Radius: 5}},
+	}
+	for _, tt := range tests {
+		got := tt.from
+		got.Step(1, size)
+		if got != tt.want {
+			t.Errorf("%+v.Step(1) = %+v, want %+v", tt.from, got, tt.want)
+		}
+	}
+}
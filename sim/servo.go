@@ -0,0 +1,143 @@
+// Package sim simulates the turret and the camera so that the whole
+// detector → turret loop can run without a raspberry, servos or a
+// camera. Servos follow the commanded angles with a limited speed and
+// targets move across the scene, which sim/camera renders as frames.
+package sim
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/gobot/sysfs"
+)
+
+// Servo is a virtual hobby servo. It implements sysfs.PWMPinner so
+// it can be given to turret.NewWithPins. The servo moves towards the
+// commanded angle at a maximum speed after a dead time, like real
+// servos do.
+type Servo struct {
+	// Speed is the maximum speed of the servo in degrees per second.
+	Speed float64
+	// DeadTime is the time it takes the servo to start moving
+	// after a command.
+	DeadTime time.Duration
+
+	mu       sync.Mutex
+	duty     uint32
+	pos      float64
+	target   float64
+	commands []Command
+	updated  time.Time
+	now      func() time.Time
+}
+
+// Command is an angle commanded to a servo.
+type Command struct {
+	Time  time.Time
+	Angle float64
+}
+
+var _ sysfs.PWMPinner = (*Servo)(nil)
+
+// NewServo creates a servo that starts at angle 0.
+func NewServo(speed float64, deadTime time.Duration) *Servo {
+	return &Servo{Speed: speed, DeadTime: deadTime, now: time.Now}
+}
+
+// Export implements sysfs.PWMPinner.
+func (s *Servo) Export() error { return nil }
+
+// Unexport implements sysfs.PWMPinner.
+func (s *Servo) Unexport() error { return nil }
+
+// Enable implements sysfs.PWMPinner.
+func (s *Servo) Enable(bool) error { return nil }
+
+// Polarity implements sysfs.PWMPinner.
+func (s *Servo) Polarity() (string, error) { return "normal", nil }
+
+// InvertPolarity implements sysfs.PWMPinner.
+func (s *Servo) InvertPolarity(bool) error { return nil }
+
+// Period implements sysfs.PWMPinner.
+func (s *Servo) Period() (uint32, error) { return 20000000, nil }
+
+// SetPeriod implements sysfs.PWMPinner.
+func (s *Servo) SetPeriod(uint32) error { return nil }
+
+// DutyCycle implements sysfs.PWMPinner.
+func (s *Servo) DutyCycle() (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duty, nil
+}
+
+// SetDutyCycle commands the servo to the angle of the duty cycle.
+func (s *Servo) SetDutyCycle(duty uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.update(now)
+	s.duty = duty
+	s.target = turret.AngleFromDutyCycle(duty)
+	s.commands = append(s.commands, Command{Time: now, Angle: s.target})
+	return nil
+}
+
+// Angle returns the angle the servo is at now.
+func (s *Servo) Angle() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(s.now())
+	return s.pos
+}
+
+// Commands returns every angle commanded to the servo.
+func (s *Servo) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.commands...)
+}
+
+// update moves the servo towards the target for the time
+// elapsed since the last update.
+func (s *Servo) update(now time.Time) {
+	start := s.updated
+	if n := len(s.commands); n > 0 {
+		if moving := s.commands[n-1].Time.Add(s.DeadTime); moving.After(start) {
+			start = moving
+		}
+	}
+	if s.updated.IsZero() || now.Before(start) {
+		if s.updated.IsZero() {
+			s.updated = now
+		}
+		return
+	}
+	step := s.Speed * now.Sub(start).Seconds()
+	if diff := s.target - s.pos; math.Abs(diff) <= step {
+		s.pos = s.target
+	} else {
+		s.pos += math.Copysign(step, diff)
+	}
+	s.updated = now
+}
+
+// Turret is a virtual pan and tilt turret made of two servos.
+type Turret struct {
+	Pan  *Servo
+	Tilt *Servo
+}
+
+// NewTurret creates a turret whose servos move at speed degrees
+// per second after deadTime.
+func NewTurret(speed float64, deadTime time.Duration) *Turret {
+	return &Turret{Pan: NewServo(speed, deadTime), Tilt: NewServo(speed, deadTime)}
+}
+
+// Aim returns the current pan and tilt angles of the turret.
+func (t *Turret) Aim() (pan, tilt float64) {
+	return t.Pan.Angle(), t.Tilt.Angle()
+}
@@ -0,0 +1,80 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// clock is a fake time source for the servos.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+// newTestServo returns a servo that moves at 100 degrees per second
+// after 100ms and the clock it uses.
+func newTestServo() (*Servo, *clock) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := NewServo(100, 100*time.Millisecond)
+	s.now = c.now
+	return s, c
+}
+
+// command commands the servo to the angle.
+func command(t *testing.T, s *Servo, angle float64) {
+	t.Helper()
+	// the duty cycle of the angle as the turret computes it.
+	dc := uint32(450000 + angle*(2350000-450000)/180)
+	if err := s.SetDutyCycle(dc); err != nil {
+		t.Fatalf("SetDutyCycle() error = %v", err)
+	}
+}
+
+func TestServoMoves(t *testing.T) {
+	s, c := newTestServo()
+	command(t, s, 90)
+	tests := []struct {
+		at   time.Duration
+		want float64
+	}{
+		// nothing moves during the dead time.
+		{50 * time.Millisecond, 0},
+		{100 * time.Millisecond, 0},
+		{200 * time.Millisecond, 10},
+		{600 * time.Millisecond, 50},
+		// the servo stops at the commanded angle.
+		{time.Second, 90},
+		{2 * time.Second, 90},
+	}
+	for _, tt := range tests {
+		c.t = time.Unix(0, 0).Add(tt.at)
+		if got := s.Angle(); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("Angle() after %v = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestServoChangesDirection(t *testing.T) {
+	s, c := newTestServo()
+	command(t, s, 90)
+	c.t = c.t.Add(600 * time.Millisecond)
+	// a new command waits for the dead time again before the servo
+	// moves back.
+	command(t, s, 0)
+	for _, tt := range []struct {
+		after time.Duration
+		want  float64
+	}{
+		{50 * time.Millisecond, 50},
+		{300 * time.Millisecond, 30},
+		{time.Second, 0},
+	} {
+		c.t = time.Unix(0, 0).Add(600*time.Millisecond + tt.after)
+		if got := s.Angle(); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("Angle() %v after the second command = %v, want %v", tt.after, got, tt.want)
+		}
+	}
+	if n := len(s.Commands()); n != 2 {
+		t.Errorf("len(Commands()) = %d, want 2", n)
+	}
+}
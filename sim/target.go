@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Target is a target that moves in a straight line across the
+// scene bouncing off its borders.
+type Target struct {
+	// X and Y is the position of the center of the target in pixels.
+	X, Y float64
+	// VX and VY is the velocity of the target in pixels per second.
+	VX, VY float64
+	// Radius is the radius of the target in pixels.
+	Radius int
+	Color  color.RGBA
+}
+
+// Point returns the position of the target rounded to the nearest pixel.
+func (t Target) Point() image.Point {
+	return image.Pt(int(math.Round(t.X)), int(math.Round(t.Y)))
+}
+
+// Step moves the target dt seconds inside a scene of the given size,
+// bouncing off its borders.
+func (t *Target) Step(dt float64, size image.Point) {
+	t.X, t.VX = bounce(t.X+t.VX*dt, t.VX, float64(t.Radius), float64(size.X-t.Radius))
+	t.Y, t.VY = bounce(t.Y+t.VY*dt, t.VY, float64(t.Radius), float64(size.Y-t.Radius))
+}
+
+// bounce reflects the position p and velocity v if p is outside of [min, max].
+func bounce(p, v, min, max float64) (float64, float64) {
+	switch {
+	case p < min:
+		return 2*min - p, -v
+	case p > max:
+		return 2*max - p, -v
+	}
+	return p, v
+}
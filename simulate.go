@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/geometry"
+	"github.com/matipan/dartagnan/hud"
+	"github.com/matipan/dartagnan/sim"
+	"github.com/matipan/dartagnan/sim/camera"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/dartagnan/window"
+	"github.com/pkg/errors"
+)
+
+var targetColors = []color.RGBA{
+	{R: 230, G: 230, B: 240},
+	{R: 20, G: 20, B: 20},
+	{R: 200, G: 40, B: 200},
+}
+
+func simCmd(args []string) error {
+	var lf logFlags
+	fs := newFlagSet("sim", "[flags]", "Runs the detector and the turret against a simulated camera and turret\nand prints a report of the aiming error.")
+	area := fs.Float64("area", 2000, "base area for motion detection")
+	fov := fs.Float64("fov", 62.2, "field of view of the simulated camera in degrees")
+	duration := fs.Duration("duration", 30*time.Second, "duration of the simulation")
+	fps := fs.Float64("fps", 15, "frames per second of the simulated camera")
+	targets := fs.Int("targets", 1, "number of moving targets")
+	speed := fs.Float64("target-speed", 120, "speed of the targets in pixels per second")
+	radius := fs.Int("target-radius", 35, "radius of the targets in pixels")
+	servoSpeed := fs.Float64("servo-speed", 300, "speed of the simulated servos in degrees per second")
+	deadTime := fs.Duration("dead-time", 20*time.Millisecond, "time the simulated servos take to start moving")
+	seed := fs.Int64("seed", 1, "seed used to place the targets")
+	show := fs.Bool("show", false, "show the windows while the simulation runs")
+	lead := fs.Duration("lead", 0, "time the turret aims ahead of the targets, it needs a detector that measures velocity")
+	compensate := fs.Bool("compensate-latency", true, "aim further ahead of the targets by the measured latency from capture to servo command")
+	var (
+		df detectorFlags
+		hf hudFlags
+	)
+	df.register(fs)
+	hf.register(fs)
+	lf.register(fs)
+	fs.Parse(args)
+	if *targets < 1 {
+		return errors.New("at least one target is needed")
+	}
+	if *radius < 1 || *radius >= imgSize/2 {
+		return errors.Errorf("target radius must be between 1 and %d", imgSize/2-1)
+	}
+
+	logs, err := lf.loggers()
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	st := sim.NewTurret(*servoSpeed, *deadTime)
+	t, err := turret.NewWithPins(st.Pan, st.Tilt, turret.Config{
+		Geometry: geometry.Geometry{
+			Camera: geometry.FromFOV(imgSize, imgSize, *fov, *fov),
+			Center: event.Angles{X: 90, Y: 90},
+		},
+		Lead:              *lead,
+		CompensateLatency: *compensate,
+		Logger:            logs.For("turret"),
+	})
+	if err != nil {
+		return err
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	ts := make([]sim.Target, *targets)
+	for i := range ts {
+		dir := rnd.Float64() * 2 * math.Pi
+		ts[i] = sim.Target{
+			X:      float64(*radius + rnd.Intn(imgSize-2**radius)),
+			Y:      float64(*radius + rnd.Intn(imgSize-2**radius)),
+			VX:     *speed * math.Cos(dir),
+			VY:     *speed * math.Sin(dir),
+			Radius: *radius,
+			Color:  targetColors[i%len(targetColors)],
+		}
+	}
+	cam := camera.New(imgSize, imgSize, *fps, *duration, ts)
+	scorer := sim.NewScorer(st, t.Angles, cam.Size(), imgSize)
+	cam.OnFrame = scorer.Record
+
+	var streamer detector.Streamer = nopStreamer{}
+	if *show {
+		wm := window.New(800, 600)
+		defer wm.Close()
+		streamer = wm
+	}
+	streamer, closeRecorder, err := hf.streamer(streamer)
+	if err != nil {
+		return err
+	}
+	defer closeRecorder()
+	h, err := hf.hud(hud.Sources{Turrets: []*turret.Turret{t}, Lead: *lead})
+	if err != nil {
+		return err
+	}
+	d, err := detector.New(cam, *area, t.HandleMotion, streamer, logs.For("detector"))
+	if err != nil {
+		return err
+	}
+	if err := df.apply(d); err != nil {
+		d.Close()
+		return err
+	}
+	d.AddOverlay(h.Overlay)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := ignoreEOF(d.Run(ctx)); err != nil {
+		return err
+	}
+	_, err = scorer.Report().WriteTo(os.Stdout)
+	return err
+}
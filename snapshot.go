@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+func snapshotCmd(args []string) error {
+	fs := newFlagSet("snapshot", "[flags]", "Captures a single frame from the camera, writes it to a file and exits.")
+	device := fs.Int("device", 0, "device ID for the camera")
+	out := fs.String("o", "snapshot.jpg", "file where the frame is written, the extension defines the format")
+	skip := fs.Int("skip", 5, "number of frames discarded before the snapshot so the camera can adjust its exposure")
+	var vf cameraFlags
+	vf.register(fs)
+	fs.Parse(args)
+
+	video, err := vf.open(*device, slog.Default())
+	if err != nil {
+		return err
+	}
+	defer video.Close()
+
+	frame := gocv.NewMat()
+	defer frame.Close()
+	for i := 0; i <= *skip; i++ {
+		if !video.Read(&frame) {
+			return errors.New("Could not read video frame")
+		}
+	}
+	if frame.Empty() {
+		return errors.New("Captured frame is empty")
+	}
+	if !gocv.IMWrite(*out, frame) {
+		return errors.Errorf("Could not write frame to %s", *out)
+	}
+	return nil
+}
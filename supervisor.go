@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/detector"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/pkg/errors"
+)
+
+const (
+	// stopTimeout is the time the supervisor waits for a detector
+	// to stop before abandoning it. A detector blocked reading from
+	// a stalled camera might never stop.
+	stopTimeout = 2 * time.Second
+	// watchdogInterval is how often the watchdog checks the time of
+	// the last frame read by the detector.
+	watchdogInterval = 500 * time.Millisecond
+)
+
+// health is the health state of the camera pipeline.
+type health string
+
+const (
+	healthStarting     health = "starting"
+	healthUp           health = "up"
+	healthReconnecting health = "reconnecting"
+	healthFailed       health = "failed"
+)
+
+var healthStates = []health{healthStarting, healthUp, healthReconnecting, healthFailed}
+
+// healthState reports the health state of the camera pipeline, the
+// gauge of the current state is 1 and the others are 0.
+var healthState = metrics.NewGaugeVec("dartagnan_health_state", "Health state of the camera pipeline.", "state")
+
+// supervisor runs the detector and watches the errors of the
+// detector and the turrets. When the camera fails or stalls it is
+// reopened with exponential backoff, creating a new detector with
+// a new background, and the turrets are parked until it is back.
+// After too many failed reconnections or consecutive servo errors
+// everything is shut down.
+type supervisor struct {
+	newDetector func() (*detector.Detector, error)
+	turrets     []*turret.Turret
+	// patrols run while the detector runs, they are stopped before
+	// the turrets are parked.
+	patrols []*turret.Patrol
+
+	// restarts is the maximum number of reconnections in a row,
+	// if it is 0 the supervisor never gives up.
+	restarts int
+	// maxServoErrors is the number of servo errors in a row after
+	// which the supervisor gives up.
+	maxServoErrors int
+	// stall is the time without frames after which the camera is
+	// considered stalled.
+	stall time.Duration
+	// minBackoff and maxBackoff bound the time between reconnections.
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// parkX and parkY is the safe position of the turrets while the
+	// camera is down.
+	parkX, parkY float64
+
+	log *slog.Logger
+
+	health     health
+	turretErrs <-chan turretError
+}
+
+func (s *supervisor) run(ctx context.Context) error {
+	s.setHealth(healthStarting)
+	s.turretErrs = mergeErrors(s.turrets)
+	var (
+		failures int
+		backoff  = s.minBackoff
+	)
+	for {
+		d, err := s.newDetector()
+		if err == nil {
+			started := time.Now()
+			err = s.watch(ctx, d)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			if _, ok := err.(servoError); ok {
+				s.setHealth(healthFailed)
+				return err
+			}
+			// a detector that ran for a while resets the backoff.
+			if time.Since(started) > s.maxBackoff {
+				failures, backoff = 0, s.minBackoff
+			}
+		}
+
+		failures++
+		// the detector already counted the frames it could not read.
+		if errors.Cause(err) != detector.ErrReadFrame {
+			metrics.Errors.With("camera").Inc()
+		}
+		if s.restarts > 0 && failures > s.restarts {
+			s.setHealth(healthFailed)
+			return errors.Wrapf(err, "Camera failed %d times in a row", failures)
+		}
+		s.setHealth(healthReconnecting)
+		s.park()
+		s.log.Error("Camera failed, reconnecting", "err", err, "attempt", failures, "backoff", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// watch runs the detector until the context is closed, the detector
+// fails or stalls or the turret fails too many times in a row.
+func (s *supervisor) watch(ctx context.Context, d *detector.Detector) error {
+	var patrols sync.WaitGroup
+	defer patrols.Wait()
+	dctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- d.Run(dctx) }()
+	for _, p := range s.patrols {
+		patrols.Add(1)
+		go func(p *turret.Patrol) {
+			defer patrols.Done()
+			p.Run(dctx)
+		}(p)
+	}
+
+	watchdog := time.NewTicker(watchdogInterval)
+	defer watchdog.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			cancel()
+			return s.stop(done)
+		case err := <-done:
+			if err == nil {
+				err = errors.New("Detector stopped unexpectedly")
+			}
+			return err
+		case <-watchdog.C:
+			if d.Paused() {
+				// no frames are read while the pipeline sleeps.
+				continue
+			}
+			since := time.Since(d.LastFrame())
+			if since > s.stall {
+				cancel()
+				s.stop(done)
+				return errors.Errorf("No frames received for %v", since.Round(time.Millisecond))
+			}
+			if s.health != healthUp && since < watchdogInterval {
+				s.setHealth(healthUp)
+			}
+		case e := <-s.turretErrs:
+			// a command that succeeded since the error resets the count.
+			n := e.turret.Failures()
+			s.log.Error("Turret failed to move", "turret", e.turret.Name(), "err", e.err, "errors_in_a_row", n)
+			if n >= s.maxServoErrors {
+				cancel()
+				s.stop(done)
+				return servoError{errors.Wrapf(e.err, "Turret %s failed %d times in a row", e.turret.Name(), n)}
+			}
+		}
+	}
+}
+
+// stop waits for a cancelled detector to finish. If it does not
+// finish in time it is abandoned, it will close itself once its
+// source returns.
+func (s *supervisor) stop(done <-chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(stopTimeout):
+		s.log.Warn("Detector did not stop in time, abandoning it")
+		return nil
+	}
+}
+
+// park moves the turrets to their safe position.
+func (s *supervisor) park() {
+	for i, t := range s.turrets {
+		if err := t.MoveX(s.parkX); err != nil {
+			s.log.Error("Could not park turret", "turret", i, "err", err)
+			continue
+		}
+		if err := t.MoveY(s.parkY); err != nil {
+			s.log.Error("Could not park turret", "turret", i, "err", err)
+		}
+	}
+}
+
+// turretError is an error reported by a turret.
+type turretError struct {
+	turret *turret.Turret
+	err    error
+}
+
+// mergeErrors forwards the errors of every turret to a single channel.
+func mergeErrors(ts []*turret.Turret) <-chan turretError {
+	errs := make(chan turretError)
+	for _, t := range ts {
+		go func(t *turret.Turret) {
+			for err := range t.Errors() {
+				errs <- turretError{t, err}
+			}
+		}(t)
+	}
+	return errs
+}
+
+func (s *supervisor) setHealth(h health) {
+	if s.health == h {
+		return
+	}
+	s.log.Info("Health changed", "from", s.health, "to", h)
+	s.health = h
+	for _, state := range healthStates {
+		v := 0.0
+		if state == h {
+			v = 1
+		}
+		healthState.With(string(state)).Set(v)
+	}
+}
+
+// servoError is returned by watch when too many consecutive
+// servo errors happened.
+type servoError struct{ error }
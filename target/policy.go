@@ -0,0 +1,109 @@
+package target
+
+import (
+	"image"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Policy chooses which track to engage. It is given every track,
+// including the ones missing in the current frame, the current
+// target, nil if there is none, and the pixel the turret aims at.
+type Policy interface {
+	Select(tracks []*Track, current *Track, aim image.Point) *Track
+}
+
+// PolicyFunc is a function that implements Policy.
+type PolicyFunc func(tracks []*Track, current *Track, aim image.Point) *Track
+
+// Select implements the Policy interface.
+func (f PolicyFunc) Select(tracks []*Track, current *Track, aim image.Point) *Track {
+	return f(tracks, current, aim)
+}
+
+// maxBy returns the track detected in the current frame with
+// the biggest score.
+func maxBy(tracks []*Track, score func(*Track) float64) *Track {
+	var (
+		best      *Track
+		bestScore float64
+	)
+	for _, t := range tracks {
+		if t.Missed > 0 {
+			continue
+		}
+		if s := score(t); best == nil || s > bestScore {
+			best, bestScore = t, s
+		}
+	}
+	return best
+}
+
+// Policies that choose a target.
+var (
+	// Largest engages the track with the biggest area.
+	Largest = PolicyFunc(func(tracks []*Track, _ *Track, _ image.Point) *Track {
+		return maxBy(tracks, func(t *Track) float64 { return t.Detection.Area })
+	})
+	// Closest engages the track closest to where the turret aims.
+	Closest = PolicyFunc(func(tracks []*Track, _ *Track, aim image.Point) *Track {
+		return maxBy(tracks, func(t *Track) float64 { return -distance(t.Detection.Middle(), aim) })
+	})
+	// Recent engages the track that appeared last.
+	Recent = PolicyFunc(func(tracks []*Track, _ *Track, _ image.Point) *Track {
+		return maxBy(tracks, func(t *Track) float64 { return float64(t.FirstSeen.UnixNano()) })
+	})
+	// Fastest engages the fastest track.
+	Fastest = PolicyFunc(func(tracks []*Track, _ *Track, _ image.Point) *Track {
+		return maxBy(tracks, (*Track).Speed)
+	})
+	// Confident engages the track with the highest confidence.
+	Confident = PolicyFunc(func(tracks []*Track, _ *Track, _ image.Point) *Track {
+		return maxBy(tracks, func(t *Track) float64 { return t.Detection.Confidence })
+	})
+)
+
+// Sticky stays on the current target until it is lost, even if
+// it is missing for a few frames, then it chooses a new one with
+// the policy.
+func Sticky(p Policy) Policy {
+	return PolicyFunc(func(tracks []*Track, current *Track, aim image.Point) *Track {
+		if current != nil {
+			for _, t := range tracks {
+				if t == current {
+					return t
+				}
+			}
+		}
+		return p.Select(tracks, current, aim)
+	})
+}
+
+var policies = map[string]Policy{
+	"largest":    Largest,
+	"closest":    Closest,
+	"recent":     Recent,
+	"fastest":    Fastest,
+	"confidence": Confident,
+	"sticky":     Sticky(Largest),
+}
+
+// PolicyNames returns the names of the policies, sorted.
+func PolicyNames() []string {
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePolicy returns the policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	p, ok := policies[name]
+	if !ok {
+		return nil, errors.Errorf("unknown target policy %q", name)
+	}
+	return p, nil
+}
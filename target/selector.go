@@ -0,0 +1,151 @@
+package target
+
+import (
+	"image"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+)
+
+// Config configures a selector.
+type Config struct {
+	// Policy is the name of the policy that chooses the target.
+	Policy string
+	// Dwell is the minimum time on a target before switching to
+	// another one, unless the target is lost.
+	Dwell time.Duration
+	// MaxDistance is the maximum distance in pixels an object can
+	// move between two frames and still be the same track.
+	MaxDistance float64
+	// MaxMissed is the number of frames in a row a track can be
+	// missing before it is lost.
+	MaxMissed int
+	// Aim returns the pixel the turret aims at, it is used by the
+	// closest policy. If it is nil the turret is assumed to aim
+	// at (0, 0).
+	Aim func() image.Point
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Selector follows the objects in motion and chooses the one the
+// turrets engage with a policy that can be changed at any time.
+type Selector struct {
+	dwell time.Duration
+	aim   func() image.Point
+	log   *slog.Logger
+
+	mu      sync.Mutex
+	tracker Tracker
+	policy  Policy
+	name    string
+	current *Track
+	// since is when the current target was chosen.
+	since time.Time
+}
+
+// NewSelector creates a selector.
+func NewSelector(cfg Config) (*Selector, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = logging.Discard()
+	}
+	p, err := ParsePolicy(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{
+		dwell:   cfg.Dwell,
+		aim:     cfg.Aim,
+		log:     cfg.Logger,
+		tracker: Tracker{MaxDistance: cfg.MaxDistance, MaxMissed: cfg.MaxMissed},
+		policy:  p,
+		name:    cfg.Policy,
+	}, nil
+}
+
+// SetPolicy changes the policy that chooses the target.
+func (s *Selector) SetPolicy(name string) error {
+	p, err := ParsePolicy(name)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy, s.name = p, name
+	s.log.Info("Target policy changed", "policy", name)
+	return nil
+}
+
+// Policy returns the name of the policy that chooses the target.
+func (s *Selector) Policy() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.name
+}
+
+// Select updates the tracks with the detections of a frame captured
+// at the given time and returns the detection of the target. It
+// returns false if there is no target or it is missing in the frame.
+func (s *Selector) Select(ds []event.Detection, at time.Time) (event.Detection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks := s.tracker.Update(ds, at)
+	metrics.Tracks.Set(float64(len(tracks)))
+	if s.current != nil && !contains(tracks, s.current) {
+		s.log.Debug("Target lost", "track", s.current.ID)
+		s.current = nil
+	}
+
+	var aim image.Point
+	if s.aim != nil {
+		aim = s.aim()
+	}
+	next := s.policy.Select(tracks, s.current, aim)
+	if next != s.current && s.current != nil && at.Sub(s.since) < s.dwell {
+		next = s.current
+	}
+	if next != s.current {
+		if next != nil {
+			metrics.TargetSwitches.Inc()
+			s.log.Debug("Target changed", "track", next.ID, "policy", s.name)
+		}
+		s.current, s.since = next, at
+	}
+
+	if s.current == nil || s.current.Missed > 0 {
+		return event.Detection{}, false
+	}
+	d := s.current.Detection
+	d.TrackID = s.current.ID
+	return d, true
+}
+
+// Tracks returns a copy of the current tracks and the ID of the
+// target, 0 if there is none.
+func (s *Selector) Tracks() ([]Track, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracks := make([]Track, len(s.tracker.Tracks()))
+	for i, t := range s.tracker.Tracks() {
+		tracks[i] = *t
+		tracks[i].Trail = append([]image.Point(nil), t.Trail...)
+	}
+	id := 0
+	if s.current != nil {
+		id = s.current.ID
+	}
+	return tracks, id
+}
+
+func contains(tracks []*Track, t *Track) bool {
+	for _, tr := range tracks {
+		if tr == t {
+			return true
+		}
+	}
+	return false
+}
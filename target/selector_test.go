@@ -0,0 +1,115 @@
+package target
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+func newTestSelector(t *testing.T, policy string, dwell time.Duration) *Selector {
+	t.Helper()
+	s, err := NewSelector(Config{
+		Policy:      policy,
+		Dwell:       dwell,
+		MaxDistance: 50,
+		MaxMissed:   2,
+		Aim:         func() image.Point { return image.Pt(400, 400) },
+	})
+	if err != nil {
+		t.Fatalf("NewSelector() error = %v", err)
+	}
+	return s
+}
+
+func TestPolicies(t *testing.T) {
+	big := at(100, 100)
+	big.Rect = image.Rect(50, 50, 150, 150)
+	big.Area = 10000
+	fast := at(250, 100)
+	fast.Velocity = event.Velocity{X: 300}
+	sure := at(100, 300)
+	sure.Confidence = 1
+	big.Confidence, fast.Confidence = 0.5, 0.5
+	near := at(380, 380)
+	near.Confidence = 0.5
+
+	tests := []struct {
+		policy string
+		want   image.Point
+	}{
+		{"largest", big.Middle()},
+		{"closest", near.Middle()},
+		{"fastest", fast.Middle()},
+		{"confidence", sure.Middle()},
+	}
+	for _, tt := range tests {
+		s := newTestSelector(t, tt.policy, 0)
+		d, ok := s.Select([]event.Detection{big, fast, sure, near}, time.Now())
+		if !ok || d.Middle() != tt.want {
+			t.Errorf("%s selected %v, want %v", tt.policy, d.Middle(), tt.want)
+		}
+	}
+
+	s := newTestSelector(t, "recent", 0)
+	now := time.Now()
+	s.Select([]event.Detection{big}, now)
+	if d, _ := s.Select([]event.Detection{big, near}, now.Add(time.Second)); d.Middle() != near.Middle() {
+		t.Errorf("recent selected %v, want %v", d.Middle(), near.Middle())
+	}
+}
+
+func TestSelectorDwell(t *testing.T) {
+	s := newTestSelector(t, "closest", time.Second)
+	now := time.Now()
+
+	d, ok := s.Select([]event.Detection{at(100, 100)}, now)
+	if !ok || d.TrackID != 1 {
+		t.Fatalf("Select() = %+v, %v, want track 1", d, ok)
+	}
+	// a closer object shows up but the turret dwells on the target.
+	d, _ = s.Select([]event.Detection{at(100, 100), at(390, 390)}, now.Add(500*time.Millisecond))
+	if d.TrackID != 1 {
+		t.Fatalf("switched to track %d before the dwell time", d.TrackID)
+	}
+	d, _ = s.Select([]event.Detection{at(100, 100), at(390, 390)}, now.Add(2*time.Second))
+	if d.TrackID != 2 {
+		t.Fatalf("Select() chose track %d after the dwell time, want 2", d.TrackID)
+	}
+}
+
+func TestSelectorSticky(t *testing.T) {
+	s := newTestSelector(t, "largest", 0)
+	if err := s.SetPolicy("sticky"); err != nil {
+		t.Fatalf("SetPolicy() error = %v", err)
+	}
+	if err := s.SetPolicy("nope"); err == nil {
+		t.Error("SetPolicy() with an unknown policy did not fail")
+	}
+	if got := s.Policy(); got != "sticky" {
+		t.Errorf("Policy() = %q, want sticky", got)
+	}
+	now := time.Now()
+	small, big := at(100, 100), at(300, 300)
+	big.Rect, big.Area = image.Rect(250, 250, 350, 350), 10000
+
+	s.Select([]event.Detection{small}, now)
+	d, ok := s.Select([]event.Detection{small, big}, now.Add(time.Second))
+	if !ok || d.TrackID != 1 {
+		t.Fatalf("sticky switched to track %d", d.TrackID)
+	}
+	// while the target is missing nothing is engaged.
+	if _, ok := s.Select([]event.Detection{big}, now.Add(2*time.Second)); ok {
+		t.Fatal("Select() returned a detection while the target is missing")
+	}
+	s.Select([]event.Detection{big}, now.Add(3*time.Second))
+	// once the target is lost the biggest one is engaged.
+	d, ok = s.Select([]event.Detection{big}, now.Add(4*time.Second))
+	if !ok || d.TrackID != 2 {
+		t.Fatalf("Select() = %+v, %v after losing the target, want track 2", d, ok)
+	}
+	if tracks, id := s.Tracks(); len(tracks) != 1 || id != 2 {
+		t.Errorf("Tracks() = %d tracks, target %d, want 1 track, target 2", len(tracks), id)
+	}
+}
@@ -0,0 +1,110 @@
+// Package target keeps track of the objects in motion across
+// frames and chooses which of them the turrets engage.
+package target
+
+import (
+	"image"
+	"math"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+// trailLength is the number of positions kept in the trail
+// of each track.
+const trailLength = 32
+
+// Track is an object followed across frames.
+type Track struct {
+	ID int
+	// Detection is the last detection of the object.
+	Detection event.Detection
+	// FirstSeen and LastSeen are when the object was first and
+	// last detected.
+	FirstSeen, LastSeen time.Time
+	// Missed is the number of frames in a row the object was not
+	// detected.
+	Missed int
+	// Trail are the last positions of the object, the last one is
+	// the most recent.
+	Trail []image.Point
+}
+
+// Speed returns the speed of the track in pixels per second.
+func (t *Track) Speed() float64 {
+	return math.Hypot(t.Detection.Velocity.X, t.Detection.Velocity.Y)
+}
+
+func (t *Track) update(d event.Detection, at time.Time) {
+	t.Detection, t.LastSeen, t.Missed = d, at, 0
+	t.Trail = append(t.Trail, d.Middle())
+	if len(t.Trail) > trailLength {
+		t.Trail = t.Trail[len(t.Trail)-trailLength:]
+	}
+}
+
+// Tracker matches the detections of each frame with the tracks
+// of the previous frames.
+type Tracker struct {
+	// MaxDistance is the maximum distance in pixels an object can
+	// move between two frames and still be the same track.
+	MaxDistance float64
+	// MaxMissed is the number of frames in a row a track can be
+	// missing before it is lost.
+	MaxMissed int
+
+	tracks []*Track
+	nextID int
+}
+
+// Update matches the detections of a frame captured at the given
+// time with the tracks. Each detection is matched with the closest
+// track, detections that match no track start a new one and tracks
+// that are missing for more than MaxMissed frames are lost. It
+// returns the current tracks.
+func (t *Tracker) Update(ds []event.Detection, at time.Time) []*Track {
+	matched := make(map[*Track]bool, len(t.tracks))
+	for _, d := range ds {
+		var (
+			best *Track
+			dist = t.MaxDistance
+		)
+		m := d.Middle()
+		for _, tr := range t.tracks {
+			if matched[tr] {
+				continue
+			}
+			if dd := distance(m, tr.Detection.Middle()); dd <= dist {
+				best, dist = tr, dd
+			}
+		}
+		if best == nil {
+			t.nextID++
+			best = &Track{ID: t.nextID, FirstSeen: at}
+			t.tracks = append(t.tracks, best)
+		}
+		best.update(d, at)
+		matched[best] = true
+	}
+
+	tracks := t.tracks[:0]
+	for _, tr := range t.tracks {
+		if !matched[tr] {
+			tr.Missed++
+		}
+		if tr.Missed <= t.MaxMissed {
+			tracks = append(tracks, tr)
+		}
+	}
+	t.tracks = tracks
+	return tracks
+}
+
+// Tracks returns the current tracks.
+func (t *Tracker) Tracks() []*Track {
+	return t.tracks
+}
+
+func distance(a, b image.Point) float64 {
+	return math.Hypot(float64(a.X-b.X), float64(a.Y-b.Y))
+}
@@ -0,0 +1,51 @@
+package target
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+// at returns a detection of 20x20 pixels centered at (x, y).
+func at(x, y int) event.Detection {
+	return event.Detection{Rect: image.Rect(x-10, y-10, x+10, y+10), Area: 400, Confidence: 1}
+}
+
+func TestTracker(t *testing.T) {
+	tr := Tracker{MaxDistance: 50, MaxMissed: 1}
+	now := time.Now()
+
+	tracks := tr.Update([]event.Detection{at(100, 100), at(300, 300)}, now)
+	if len(tracks) != 2 || tracks[0].ID != 1 || tracks[1].ID != 2 {
+		t.Fatalf("Update() = %v, want tracks 1 and 2", tracks)
+	}
+
+	// both objects move a bit, they keep their IDs even if the
+	// detections come in a different order.
+	tracks = tr.Update([]event.Detection{at(310, 290), at(120, 100)}, now.Add(time.Second))
+	if len(tracks) != 2 {
+		t.Fatalf("Update() returned %d tracks, want 2", len(tracks))
+	}
+	for _, tt := range tracks {
+		want := map[int]image.Point{1: image.Pt(120, 100), 2: image.Pt(310, 290)}[tt.ID]
+		if got := tt.Detection.Middle(); got != want {
+			t.Errorf("track %d is at %v, want %v", tt.ID, got, want)
+		}
+	}
+	if got := len(tracks[0].Trail); got != 2 {
+		t.Errorf("trail has %d points, want 2", got)
+	}
+
+	// an object that jumps too far is a new track and the old one
+	// is missing until it is lost.
+	tracks = tr.Update([]event.Detection{at(120, 100), at(450, 50)}, now.Add(2*time.Second))
+	if len(tracks) != 3 || tracks[1].Missed != 1 || tracks[2].ID != 3 {
+		t.Fatalf("Update() = %+v, want track 2 missing and a new track 3", tracks)
+	}
+	tracks = tr.Update([]event.Detection{at(120, 100), at(450, 50)}, now.Add(3*time.Second))
+	if len(tracks) != 2 || tracks[0].ID != 1 || tracks[1].ID != 3 {
+		t.Fatalf("Update() = %+v, want tracks 1 and 3", tracks)
+	}
+}
@@ -0,0 +1,144 @@
+package trigger
+
+import (
+	"time"
+
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/gobot/drivers/gpio"
+	"github.com/matipan/gobot/sysfs"
+	"github.com/pkg/errors"
+)
+
+// Actuator is what the trigger turns on to fire. gpio.RelayDriver,
+// gpio.DirectPinDriver and gpio.LedDriver implement it.
+type Actuator interface {
+	On() error
+	Off() error
+}
+
+var (
+	_ Actuator = (*gpio.RelayDriver)(nil)
+	_ Actuator = (*gpio.DirectPinDriver)(nil)
+)
+
+// Nop is an actuator that does nothing. It is used when the trigger
+// only indicates targets, for example with a laser pointer.
+type Nop struct{}
+
+// On does nothing.
+func (Nop) On() error { return nil }
+
+// Off does nothing.
+func (Nop) Off() error { return nil }
+
+// Servo moves to an angle, PWMServo implements it.
+type Servo interface {
+	Move(angle uint8) error
+}
+
+// PWMServo is a servo on a PWM pin with a period of 20ms. It is
+// driven with the same pulses as the servos of the turret.
+type PWMServo struct {
+	Pin sysfs.PWMPinner
+}
+
+var _ Servo = PWMServo{}
+
+// Move moves the servo to the angle.
+func (s PWMServo) Move(angle uint8) error {
+	return s.Pin.SetDutyCycle(turret.DutyCycle(float64(angle)))
+}
+
+// ServoActuator fires by moving a servo, for example one that
+// pulls a trigger, from its rest angle to its fire angle.
+type ServoActuator struct {
+	Servo Servo
+	Rest  uint8
+	Fire  uint8
+}
+
+// On moves the servo to the fire angle.
+func (s ServoActuator) On() error { return s.Servo.Move(s.Fire) }
+
+// Off moves the servo to the rest angle.
+func (s ServoActuator) Off() error { return s.Servo.Move(s.Rest) }
+
+// KillSwitch is an input that disarms the trigger while it is
+// pressed. Button implements it.
+type KillSwitch interface {
+	On(name string, f func(s interface{})) error
+	Start() error
+	// Engaged reads whether the switch is pressed now.
+	Engaged() (bool, error)
+}
+
+// Button is a kill switch on a gpio.ButtonDriver. Reader is what
+// the driver reads the pin from, it is used to read the level of
+// the button before it is watched.
+type Button struct {
+	*gpio.ButtonDriver
+	Reader gpio.DigitalReader
+}
+
+var _ KillSwitch = Button{}
+
+// Engaged reads whether the button is pushed.
+func (b Button) Engaged() (bool, error) {
+	v, err := b.Reader.DigitalRead(b.Pin())
+	if err != nil {
+		return false, err
+	}
+	return v != b.DefaultState, nil
+}
+
+// WatchKillSwitch engages the kill switch of the trigger while the
+// button is pushed and releases it when the button is released. The
+// button is read first, so the trigger cannot be armed if it is
+// already pushed. An error reading the button engages the kill
+// switch too, so a broken button cannot leave the trigger armable.
+func (t *Trigger) WatchKillSwitch(k KillSwitch) error {
+	engaged, err := k.Engaged()
+	if err != nil {
+		return errors.Wrap(err, "Could not read the kill switch")
+	}
+	if engaged {
+		if err := t.Kill(); err != nil {
+			return err
+		}
+	}
+	if err := k.On(gpio.ButtonPush, func(interface{}) { t.Kill() }); err != nil {
+		return err
+	}
+	if err := k.On(gpio.ButtonRelease, func(interface{}) { t.Release() }); err != nil {
+		return err
+	}
+	// the button publishes an error on every failed read.
+	warn := logging.Throttled(t.log, time.Second)
+	if err := k.On(gpio.Error, func(err interface{}) {
+		metrics.Errors.With("trigger").Inc()
+		warn.Error("Could not read the kill switch", "err", err)
+		t.Kill()
+	}); err != nil {
+		return err
+	}
+	return k.Start()
+}
+
+// Indicate turns the actuator on while the trigger is locked on a
+// target or firing, for example a laser pointer that shows where
+// the turret aims.
+func (t *Trigger) Indicate(a Actuator) {
+	t.OnChange(func(from, to State) {
+		var err error
+		if to == LockedOn || to == Firing {
+			err = a.On()
+		} else if from == LockedOn || from == Firing {
+			err = a.Off()
+		}
+		if err != nil {
+			t.log.Error("Could not switch the indicator", "state", to, "err", err)
+		}
+	})
+}
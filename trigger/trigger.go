@@ -0,0 +1,335 @@
+// Package trigger implements the firing subsystem of the turret. A
+// trigger is a state machine that goes from disarmed to armed, locks
+// on a target once it is detected, fires when the lock is stable and
+// cools down before it can fire again:
+//
+//	disarmed → armed → locked-on → firing → cooldown → armed
+//
+// Disarming, or the kill switch, stops it at any point.
+package trigger
+
+import (
+	"image"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/pkg/errors"
+)
+
+// State is the state of the trigger.
+type State int
+
+// States of the trigger.
+const (
+	Disarmed State = iota
+	Armed
+	LockedOn
+	Firing
+	Cooldown
+)
+
+var states = []State{Disarmed, Armed, LockedOn, Firing, Cooldown}
+
+func (s State) String() string {
+	switch s {
+	case Disarmed:
+		return "disarmed"
+	case Armed:
+		return "armed"
+	case LockedOn:
+		return "locked-on"
+	case Firing:
+		return "firing"
+	case Cooldown:
+		return "cooldown"
+	}
+	return "unknown"
+}
+
+// ErrKilled is returned when arming while the kill switch is engaged.
+var ErrKilled = errors.New("Kill switch is engaged")
+
+// Config configures the trigger.
+type Config struct {
+	// LockFrames is the number of consecutive stable detections
+	// needed before firing.
+	LockFrames int
+	// LockRadius is the maximum distance in pixels the target can
+	// move between two detections and still be a stable lock.
+	LockRadius int
+	// LockTimeout is the maximum time between two detections of
+	// a locked target. After it the lock is lost.
+	LockTimeout time.Duration
+	// MinConfidence is the minimum confidence of a detection to
+	// lock on it.
+	MinConfidence float64
+	// Burst is how long the actuator is on when firing.
+	Burst time.Duration
+	// Cooldown is the time after a burst before the trigger can
+	// lock on a target again.
+	Cooldown time.Duration
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Trigger fires the actuator at stable targets.
+type Trigger struct {
+	actuator Actuator
+	cfg      Config
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	state  State
+	killed bool
+	// stable is the number of consecutive stable detections of
+	// the locked target, last its position and seen when it
+	// was last detected.
+	stable int
+	last   image.Point
+	seen   time.Time
+	timer  *time.Timer
+	// lockTimer drops the lock when the target is not detected
+	// for LockTimeout.
+	lockTimer *time.Timer
+	// onChange are called with mu held every time the state changes.
+	onChange []func(from, to State)
+}
+
+// New creates a disarmed trigger that fires the actuator. The
+// actuator is turned off.
+func New(a Actuator, cfg Config) (*Trigger, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = logging.Discard()
+	}
+	if cfg.LockFrames < 1 {
+		cfg.LockFrames = 1
+	}
+	if err := a.Off(); err != nil {
+		return nil, errors.Wrap(err, "Could not turn off the actuator")
+	}
+	t := &Trigger{actuator: a, cfg: cfg, log: cfg.Logger}
+	setGauge(Disarmed)
+	return t, nil
+}
+
+// OnChange registers a function that is called every time the
+// state changes. It must not call the trigger.
+func (t *Trigger) OnChange(fn func(from, to State)) {
+	t.mu.Lock()
+	t.onChange = append(t.onChange, fn)
+	t.mu.Unlock()
+}
+
+// State returns the current state.
+func (t *Trigger) State() State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Arm arms the trigger. It fails if the kill switch is engaged.
+func (t *Trigger) Arm() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.killed {
+		return ErrKilled
+	}
+	if t.state == Disarmed {
+		t.set(Armed)
+	}
+	return nil
+}
+
+// Disarm disarms the trigger, turning off the actuator if it was firing.
+func (t *Trigger) Disarm() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.disarm()
+}
+
+func (t *Trigger) disarm() error {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.stopLockTimer()
+	err := t.actuator.Off()
+	t.stable = 0
+	t.set(Disarmed)
+	if err != nil {
+		metrics.Errors.With("trigger").Inc()
+		return errors.Wrap(err, "Could not turn off the actuator")
+	}
+	return nil
+}
+
+// Kill engages the kill switch: the trigger is disarmed and cannot
+// be armed again until Release is called.
+func (t *Trigger) Kill() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.killed {
+		t.log.Warn("Kill switch engaged")
+	}
+	t.killed = true
+	return t.disarm()
+}
+
+// Release releases the kill switch. The trigger stays disarmed.
+func (t *Trigger) Release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.killed {
+		t.log.Info("Kill switch released")
+	}
+	t.killed = false
+}
+
+// Engage moves the state machine towards firing at the detection.
+// onTarget tells whether the turret points at it: the trigger never
+// locks on nor fires at a target the turret did not reach because the
+// limits rejected or clamped the move or a servo ignores its commands.
+func (t *Trigger) Engage(d event.Detection, onTarget bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if d.Confidence < t.cfg.MinConfidence {
+		return
+	}
+	p := d.Middle()
+
+	switch t.state {
+	case Armed:
+		if onTarget {
+			t.lock(p, now)
+		}
+	case LockedOn:
+		if !onTarget {
+			t.log.Debug("Lock lost, the turret does not point at the target")
+			t.stopLockTimer()
+			t.stable = 0
+			t.set(Armed)
+			return
+		}
+		if now.Sub(t.seen) > t.cfg.LockTimeout || !near(p, t.last, t.cfg.LockRadius) {
+			t.log.Debug("Lock lost")
+			t.set(Armed)
+			t.lock(p, now)
+			return
+		}
+		t.stable++
+		t.last, t.seen = p, now
+		t.lockTimer.Reset(t.cfg.LockTimeout)
+		if t.stable >= t.cfg.LockFrames {
+			t.fire()
+		}
+	}
+}
+
+// lock locks on the target at p.
+func (t *Trigger) lock(p image.Point, now time.Time) {
+	t.stable, t.last, t.seen = 1, p, now
+	t.stopLockTimer()
+	t.lockTimer = time.AfterFunc(t.cfg.LockTimeout, t.lockLost)
+	t.set(LockedOn)
+	if t.stable >= t.cfg.LockFrames {
+		t.fire()
+	}
+}
+
+// lockLost drops the lock when the target was not detected for
+// LockTimeout.
+func (t *Trigger) lockLost() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the timer may have fired while a detection reset it.
+	if t.state != LockedOn || time.Since(t.seen) < t.cfg.LockTimeout {
+		return
+	}
+	t.log.Debug("Lock lost, the target was not detected", "timeout", t.cfg.LockTimeout)
+	t.lockTimer = nil
+	t.stable = 0
+	t.set(Armed)
+}
+
+// stopLockTimer stops the lock timeout. It must be called with mu held.
+func (t *Trigger) stopLockTimer() {
+	if t.lockTimer != nil {
+		t.lockTimer.Stop()
+		t.lockTimer = nil
+	}
+}
+
+// fire turns on the actuator for the burst and then cools down.
+func (t *Trigger) fire() {
+	t.stopLockTimer()
+	if err := t.actuator.On(); err != nil {
+		metrics.Errors.With("trigger").Inc()
+		t.log.Error("Could not fire", "err", err)
+		t.disarm()
+		return
+	}
+	metrics.TriggerShots.Inc()
+	t.set(Firing)
+	t.timer = time.AfterFunc(t.cfg.Burst, t.endBurst)
+}
+
+func (t *Trigger) endBurst() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state != Firing {
+		return
+	}
+	if err := t.actuator.Off(); err != nil {
+		metrics.Errors.With("trigger").Inc()
+		t.log.Error("Could not stop firing", "err", err)
+		t.disarm()
+		return
+	}
+	t.set(Cooldown)
+	t.timer = time.AfterFunc(t.cfg.Cooldown, t.endCooldown)
+}
+
+func (t *Trigger) endCooldown() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.state != Cooldown {
+		return
+	}
+	t.timer = nil
+	t.stable = 0
+	t.set(Armed)
+}
+
+// set changes the state. It must be called with mu held.
+func (t *Trigger) set(s State) {
+	if t.state == s {
+		return
+	}
+	from := t.state
+	t.state = s
+	t.log.Info("Trigger state changed", "from", from, "to", s)
+	setGauge(s)
+	for _, fn := range t.onChange {
+		fn(from, s)
+	}
+}
+
+func setGauge(current State) {
+	for _, s := range states {
+		v := 0.0
+		if s == current {
+			v = 1
+		}
+		metrics.TriggerState.With(s.String()).Set(v)
+	}
+}
+
+func near(a, b image.Point, radius int) bool {
+	d := a.Sub(b)
+	return d.X*d.X+d.Y*d.Y <= radius*radius
+}
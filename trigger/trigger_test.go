@@ -0,0 +1,369 @@
+package trigger
+
+import (
+	"image"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/geometry"
+	"github.com/matipan/dartagnan/turret"
+	"github.com/matipan/gobot/drivers/gpio"
+	"github.com/matipan/gobot/platforms/raspi"
+	"github.com/matipan/gobot/sysfs"
+	"github.com/pkg/errors"
+)
+
+// fakeActuator records whether it is on and how many times it was
+// turned on.
+type fakeActuator struct {
+	mu    sync.Mutex
+	on    bool
+	shots int
+	err   error
+}
+
+func (f *fakeActuator) On() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.on = true
+	f.shots++
+	return nil
+}
+
+func (f *fakeActuator) Off() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.on = false
+	return nil
+}
+
+func (f *fakeActuator) state() (bool, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.on, f.shots
+}
+
+func newTestTrigger(t *testing.T, a Actuator) *Trigger {
+	t.Helper()
+	tr, err := New(a, Config{
+		LockFrames:    3,
+		LockRadius:    10,
+		LockTimeout:   time.Second,
+		MinConfidence: 0.5,
+		Burst:         20 * time.Millisecond,
+		Cooldown:      20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return tr
+}
+
+func detection(x, y int, confidence float64) event.Detection {
+	return event.Detection{Rect: image.Rect(x-5, y-5, x+5, y+5), Confidence: confidence}
+}
+
+func waitState(t *testing.T, tr *Trigger, want State) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for tr.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("State() = %v, want %v", tr.State(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTriggerFires(t *testing.T) {
+	a := &fakeActuator{}
+	tr := newTestTrigger(t, a)
+
+	tr.Engage(detection(100, 100, 1), true)
+	if got := tr.State(); got != Disarmed {
+		t.Fatalf("disarmed trigger changed to %v", got)
+	}
+	if err := tr.Arm(); err != nil {
+		t.Fatalf("Arm() error = %v", err)
+	}
+
+	tr.Engage(detection(100, 100, 0.1), true)
+	if got := tr.State(); got != Armed {
+		t.Fatalf("low confidence detection changed state to %v", got)
+	}
+	tr.Engage(detection(100, 100, 1), true)
+	tr.Engage(detection(104, 103, 1), true)
+	if got := tr.State(); got != LockedOn {
+		t.Fatalf("State() = %v, want %v", got, LockedOn)
+	}
+	tr.Engage(detection(106, 105, 1), true)
+	if got := tr.State(); got != Firing {
+		t.Fatalf("State() = %v, want %v", got, Firing)
+	}
+	if on, shots := a.state(); !on || shots != 1 {
+		t.Fatalf("actuator on = %v shots = %d, want on with 1 shot", on, shots)
+	}
+
+	waitState(t, tr, Cooldown)
+	if on, _ := a.state(); on {
+		t.Fatal("actuator still on after the burst")
+	}
+	tr.Engage(detection(100, 100, 1), true)
+	if got := tr.State(); got != Cooldown {
+		t.Fatalf("detection during cooldown changed state to %v", got)
+	}
+	waitState(t, tr, Armed)
+}
+
+func TestTriggerLockLost(t *testing.T) {
+	a := &fakeActuator{}
+	tr := newTestTrigger(t, a)
+	tr.Arm()
+
+	tr.Engage(detection(100, 100, 1), true)
+	tr.Engage(detection(105, 100, 1), true)
+	// The target jumps outside the lock radius, so the lock starts over.
+	tr.Engage(detection(200, 200, 1), true)
+	tr.Engage(detection(202, 200, 1), true)
+	if got := tr.State(); got != LockedOn {
+		t.Fatalf("State() = %v, want %v", got, LockedOn)
+	}
+	if _, shots := a.state(); shots != 0 {
+		t.Fatalf("fired %d times with an unstable lock", shots)
+	}
+}
+
+func TestTriggerLockTimeout(t *testing.T) {
+	a, laser := &fakeActuator{}, &fakeActuator{}
+	tr, err := New(a, Config{
+		LockFrames:    3,
+		LockRadius:    10,
+		LockTimeout:   30 * time.Millisecond,
+		MinConfidence: 0.5,
+		Burst:         20 * time.Millisecond,
+		Cooldown:      20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tr.Indicate(laser)
+	tr.Arm()
+
+	tr.Engage(detection(100, 100, 1), true)
+	tr.Engage(detection(100, 100, 1), true)
+	// the target disappears, so the lock is lost without another
+	// detection and the laser is turned off.
+	waitState(t, tr, Armed)
+	if on, _ := laser.state(); on {
+		t.Fatal("laser is on after the lock was lost")
+	}
+	// a stray detection much later starts a new lock instead of
+	// completing the old one.
+	tr.Engage(detection(100, 100, 1), true)
+	if got := tr.State(); got != LockedOn {
+		t.Fatalf("State() = %v, want %v", got, LockedOn)
+	}
+	if _, shots := a.state(); shots != 0 {
+		t.Fatalf("fired %d times with a lost lock", shots)
+	}
+}
+
+func TestTriggerKill(t *testing.T) {
+	a := &fakeActuator{}
+	tr := newTestTrigger(t, a)
+	tr.Arm()
+	for i := 0; i < 3; i++ {
+		tr.Engage(detection(100, 100, 1), true)
+	}
+	if got := tr.State(); got != Firing {
+		t.Fatalf("State() = %v, want %v", got, Firing)
+	}
+
+	if err := tr.Kill(); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+	if on, _ := a.state(); on {
+		t.Fatal("actuator still on after the kill switch")
+	}
+	if got := tr.State(); got != Disarmed {
+		t.Fatalf("State() = %v, want %v", got, Disarmed)
+	}
+	if err := tr.Arm(); err != ErrKilled {
+		t.Fatalf("Arm() error = %v, want %v", err, ErrKilled)
+	}
+	tr.Release()
+	if err := tr.Arm(); err != nil {
+		t.Fatalf("Arm() after release error = %v", err)
+	}
+}
+
+func TestTriggerActuatorError(t *testing.T) {
+	a := &fakeActuator{err: errors.New("relay is gone")}
+	tr := newTestTrigger(t, a)
+	tr.Arm()
+	for i := 0; i < 3; i++ {
+		tr.Engage(detection(100, 100, 1), true)
+	}
+	if got := tr.State(); got != Disarmed {
+		t.Fatalf("State() = %v, want %v after the actuator failed", got, Disarmed)
+	}
+}
+
+func TestTriggerIndicate(t *testing.T) {
+	laser := &fakeActuator{}
+	tr := newTestTrigger(t, &fakeActuator{})
+	tr.Indicate(laser)
+	tr.Arm()
+
+	tr.Engage(detection(100, 100, 1), true)
+	if on, _ := laser.state(); !on {
+		t.Fatal("laser is off while locked on")
+	}
+	for i := 0; i < 2; i++ {
+		tr.Engage(detection(100, 100, 1), true)
+	}
+	if on, _ := laser.state(); !on {
+		t.Fatal("laser is off while firing")
+	}
+	waitState(t, tr, Cooldown)
+	if on, _ := laser.state(); on {
+		t.Fatal("laser is on during the cooldown")
+	}
+}
+
+func TestTriggerNeverFiresIntoForbiddenZone(t *testing.T) {
+	fs := sysfs.NewMockFilesystem([]string{"/dev/pi-blaster"})
+	sysfs.SetFilesystem(fs)
+	t.Cleanup(func() { sysfs.SetFilesystem(&sysfs.NativeFilesystem{}) })
+	sx, sy := raspi.NewPWMPin("13"), raspi.NewPWMPin("19")
+	sx.SetPeriod(20000000)
+	sy.SetPeriod(20000000)
+	// the middle of the image is at (90, 90), inside the zone.
+	tu, err := turret.NewWithPins(sx, sy, turret.Config{
+		Geometry: geometry.Geometry{
+			Camera: geometry.FromFOV(500, 500, 90, 90),
+			Center: event.Angles{X: 90, Y: 90},
+		},
+		Limits: turret.Limits{Zones: []turret.Zone{{Name: "door", MinX: 80, MaxX: 100, MinY: 80, MaxY: 100}}},
+	})
+	if err != nil {
+		t.Fatalf("NewWithPins() error = %v", err)
+	}
+	a := &fakeActuator{}
+	tr := newTestTrigger(t, a)
+	tr.Arm()
+
+	for i := 0; i < 10; i++ {
+		d := detection(250+i%2, 250, 1)
+		tr.Engage(d, tu.Aim(d).OnTarget())
+		if got := tr.State(); got == LockedOn || got == Firing {
+			t.Fatalf("State() = %v aiming into a forbidden zone", got)
+		}
+	}
+	if _, shots := a.state(); shots != 0 {
+		t.Fatalf("fired %d times into a forbidden zone", shots)
+	}
+}
+
+func TestTriggerLockLostOffTarget(t *testing.T) {
+	a := &fakeActuator{}
+	tr := newTestTrigger(t, a)
+	tr.Arm()
+
+	tr.Engage(detection(100, 100, 1), true)
+	tr.Engage(detection(100, 100, 1), false)
+	if got := tr.State(); got != Armed {
+		t.Fatalf("State() = %v, want %v once the turret is off target", got, Armed)
+	}
+	tr.Engage(detection(100, 100, 1), true)
+	tr.Engage(detection(100, 100, 1), true)
+	if got := tr.State(); got != LockedOn {
+		t.Fatalf("State() = %v, want the lock to start over", got)
+	}
+}
+
+// fakeKillSwitch is a kill switch whose handlers are called by hand.
+type fakeKillSwitch struct {
+	engaged  bool
+	handlers map[string]func(interface{})
+}
+
+func (f *fakeKillSwitch) On(name string, fn func(interface{})) error {
+	if f.handlers == nil {
+		f.handlers = make(map[string]func(interface{}))
+	}
+	f.handlers[name] = fn
+	return nil
+}
+
+func (f *fakeKillSwitch) Start() error { return nil }
+
+func (f *fakeKillSwitch) Engaged() (bool, error) { return f.engaged, nil }
+
+func TestWatchKillSwitchEngaged(t *testing.T) {
+	tr := newTestTrigger(t, &fakeActuator{})
+	k := &fakeKillSwitch{engaged: true}
+	if err := tr.WatchKillSwitch(k); err != nil {
+		t.Fatalf("WatchKillSwitch() error = %v", err)
+	}
+	if err := tr.Arm(); err != ErrKilled {
+		t.Fatalf("Arm() error = %v with the switch pushed, want %v", err, ErrKilled)
+	}
+	k.handlers[gpio.ButtonRelease](0)
+	if err := tr.Arm(); err != nil {
+		t.Fatalf("Arm() after release error = %v", err)
+	}
+	k.handlers[gpio.ButtonPush](1)
+	if got := tr.State(); got != Disarmed {
+		t.Fatalf("State() = %v after the push, want %v", got, Disarmed)
+	}
+}
+
+func TestWatchKillSwitchError(t *testing.T) {
+	tr := newTestTrigger(t, &fakeActuator{})
+	k := &fakeKillSwitch{}
+	if err := tr.WatchKillSwitch(k); err != nil {
+		t.Fatalf("WatchKillSwitch() error = %v", err)
+	}
+	if err := tr.Arm(); err != nil {
+		t.Fatalf("Arm() error = %v", err)
+	}
+	k.handlers[gpio.Error](errors.New("read failed"))
+	if got := tr.State(); got != Disarmed {
+		t.Fatalf("State() = %v after a kill switch error, want %v", got, Disarmed)
+	}
+	if err := tr.Arm(); err != ErrKilled {
+		t.Fatalf("Arm() error = %v after a kill switch error, want %v", err, ErrKilled)
+	}
+}
+
+func TestServoActuator(t *testing.T) {
+	fs := sysfs.NewMockFilesystem([]string{"/dev/pi-blaster"})
+	sysfs.SetFilesystem(fs)
+	t.Cleanup(func() { sysfs.SetFilesystem(&sysfs.NativeFilesystem{}) })
+	pin := raspi.NewPWMPin("18")
+	pin.SetPeriod(20000000)
+	a := ServoActuator{Servo: PWMServo{Pin: pin}, Rest: 10, Fire: 90}
+
+	tests := []struct {
+		name string
+		move func() error
+		want string
+	}{
+		{"fire", a.On, "18=0.07\n"},
+		{"rest", a.Off, "18=0.0277778\n"},
+	}
+	for _, tt := range tests {
+		fs.Files["/dev/pi-blaster"].Contents = ""
+		if err := tt.move(); err != nil {
+			t.Fatalf("%s: error = %v", tt.name, err)
+		}
+		if got := fs.Files["/dev/pi-blaster"].Contents; got != tt.want {
+			t.Errorf("%s: pi-blaster = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package turret
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Limits are the mechanical safety limits of a turret. Every
+// command sent to the servos, whether it comes from tracking,
+// a sweep or a direct move, is checked against them.
+type Limits struct {
+	// MinX, MaxX, MinY and MaxY are the soft limits of each axis
+	// in degrees. A zero MaxX or MaxY means 180.
+	MinX, MaxX float64
+	MinY, MaxY float64
+	// Zones are the regions the turret must never point at.
+	Zones []Zone
+	// Reject makes commands that violate the limits fail instead
+	// of being clamped to the closest allowed position.
+	Reject bool
+}
+
+// Zone is a forbidden region of angles. Both ranges are inclusive.
+type Zone struct {
+	Name       string
+	MinX, MaxX float64
+	MinY, MaxY float64
+}
+
+// zoneMargin is how many degrees out of a zone a position inside
+// it is moved to.
+const zoneMargin = 1
+
+// contains reports whether the angles are inside the zone.
+func (z Zone) contains(x, y float64) bool {
+	return x >= z.MinX && x <= z.MaxX && y >= z.MinY && y <= z.MaxY
+}
+
+// LimitError is returned when a command violates the limits and
+// the limits reject it.
+type LimitError struct {
+	// Limit is the name of the zone or "soft limit".
+	Limit string
+	X, Y  float64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Position (%g, %g) violates %s", e.X, e.Y, e.Limit)
+}
+
+const softLimit = "soft limit"
+
+// withDefaults fills the unset maximums.
+func (l Limits) withDefaults() Limits {
+	if l.MaxX == 0 {
+		l.MaxX = 180
+	}
+	if l.MaxY == 0 {
+		l.MaxY = 180
+	}
+	return l
+}
+
+// validate checks that the limits leave somewhere to aim at.
+func (l Limits) validate() error {
+	if l.MinX < 0 || l.MinY < 0 || l.MinX > l.MaxX || l.MinY > l.MaxY || l.MaxX > 180 || l.MaxY > 180 {
+		return errors.Errorf("invalid soft limits x=%g:%g y=%g:%g", l.MinX, l.MaxX, l.MinY, l.MaxY)
+	}
+	for _, z := range l.Zones {
+		if z.MinX > z.MaxX || z.MinY > z.MaxY {
+			return errors.Errorf("invalid zone %s", z.Name)
+		}
+	}
+	return nil
+}
+
+// zoneAt returns the zone that contains the angles.
+func (l Limits) zoneAt(x, y float64) (Zone, bool) {
+	for _, z := range l.Zones {
+		if z.contains(x, y) {
+			return z, true
+		}
+	}
+	return Zone{}, false
+}
+
+func (l Limits) allowed(x, y float64) bool {
+	if x < l.MinX || x > l.MaxX || y < l.MinY || y > l.MaxY {
+		return false
+	}
+	_, in := l.zoneAt(x, y)
+	return !in
+}
+
+// apply returns the position closest to (x, y) that respects the
+// limits moving only the axes in moveX and moveY. violated is the
+// name of the limit that was violated, if any. If there is no
+// allowed position, or the limits reject violations, an error is
+// returned.
+func (l Limits) apply(x, y float64, moveX, moveY bool) (nx, ny float64, violated string, err error) {
+	nx, ny = x, y
+	if moveX {
+		nx = clamp(x, l.MinX, l.MaxX)
+	}
+	if moveY {
+		ny = clamp(y, l.MinY, l.MaxY)
+	}
+	if nx != x || ny != y {
+		violated = softLimit
+	}
+	if z, in := l.zoneAt(nx, ny); in {
+		violated = z.Name
+		// move out of the zone through its closest edge.
+		var candidates [][2]float64
+		if moveX {
+			candidates = append(candidates, [2]float64{z.MinX - zoneMargin, ny}, [2]float64{z.MaxX + zoneMargin, ny})
+		}
+		if moveY {
+			candidates = append(candidates, [2]float64{nx, z.MinY - zoneMargin}, [2]float64{nx, z.MaxY + zoneMargin})
+		}
+		best := math.Inf(1)
+		found := false
+		for _, c := range candidates {
+			if !l.allowed(c[0], c[1]) {
+				continue
+			}
+			if d := math.Hypot(c[0]-x, c[1]-y); d < best {
+				best, found = d, true
+				nx, ny = c[0], c[1]
+			}
+		}
+		if !found {
+			return x, y, violated, &LimitError{Limit: violated, X: x, Y: y}
+		}
+	}
+	if violated != "" && l.Reject {
+		return x, y, violated, &LimitError{Limit: violated, X: x, Y: y}
+	}
+	return nx, ny, violated, nil
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// ParseRange parses an inclusive range of angles such as "10:170".
+func ParseRange(s string) (min, max float64, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid range %q, expected min:max", s)
+	}
+	lo, err := parseAngle(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := parseAngle(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo > hi {
+		return 0, 0, errors.Errorf("invalid range %q, min is bigger than max", s)
+	}
+	return lo, hi, nil
+}
+
+// ParseZone parses a zone such as "door:120:150:0:180", which is the
+// name followed by the range of X and the range of Y.
+func ParseZone(s string) (Zone, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return Zone{}, errors.Errorf("invalid zone %q, expected name:minX:maxX:minY:maxY", s)
+	}
+	r := strings.Split(parts[1], ":")
+	if len(r) != 4 {
+		return Zone{}, errors.Errorf("invalid zone %q, expected name:minX:maxX:minY:maxY", s)
+	}
+	z := Zone{Name: parts[0]}
+	var err error
+	if z.MinX, z.MaxX, err = ParseRange(r[0] + ":" + r[1]); err != nil {
+		return Zone{}, errors.Wrapf(err, "invalid zone %s", z.Name)
+	}
+	if z.MinY, z.MaxY, err = ParseRange(r[2] + ":" + r[3]); err != nil {
+		return Zone{}, errors.Wrapf(err, "invalid zone %s", z.Name)
+	}
+	return z, nil
+}
+
+func parseAngle(s string) (float64, error) {
+	a, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || !(a >= 0 && a <= 180) {
+		return 0, errors.Errorf("invalid angle %q, must be between 0 and 180", s)
+	}
+	return a, nil
+}
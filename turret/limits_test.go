@@ -0,0 +1,96 @@
+package turret
+
+import (
+	"image"
+	"testing"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+func TestLimitsApply(t *testing.T) {
+	door := Zone{Name: "door", MinX: 120, MaxX: 150, MinY: 0, MaxY: 180}
+	floor := Zone{Name: "floor", MinX: 0, MaxX: 180, MinY: 0, MaxY: 20}
+	tests := []struct {
+		name         string
+		limits       Limits
+		x, y         float64
+		moveX, moveY bool
+		wantX, wantY float64
+		violated     string
+		wantErr      bool
+	}{
+		{"allowed", Limits{MaxX: 180, MaxY: 180}, 90, 90, true, true, 90, 90, "", false},
+		{"soft limit", Limits{MinX: 10, MaxX: 170, MinY: 30, MaxY: 180}, 0, 10, true, true, 10, 30, softLimit, false},
+		{"soft limit only on moved axis", Limits{MinX: 10, MaxX: 170, MaxY: 180}, 0, 0, false, true, 0, 0, "", false},
+		{"fractional soft limit", Limits{MinX: 10.5, MaxX: 170, MaxY: 180}, 10.25, 90.75, true, true, 10.5, 90.75, softLimit, false},
+		{"zone closest edge", Limits{MaxX: 180, MaxY: 180, Zones: []Zone{door}}, 125, 90, true, true, 119, 90, "door", false},
+		{"zone other edge", Limits{MaxX: 180, MaxY: 180, Zones: []Zone{door}}, 148, 90, true, true, 151, 90, "door", false},
+		{"zone on y", Limits{MaxX: 180, MaxY: 180, Zones: []Zone{floor}}, 90, 5, true, true, 90, 21, "floor", false},
+		{"zone without way out", Limits{MaxX: 180, MaxY: 180, Zones: []Zone{door}}, 130, 90, false, true, 130, 90, "door", true},
+		{"reject", Limits{MaxX: 180, MaxY: 180, Zones: []Zone{door}, Reject: true}, 125, 90, true, true, 125, 90, "door", true},
+	}
+	for _, tt := range tests {
+		x, y, violated, err := tt.limits.apply(tt.x, tt.y, tt.moveX, tt.moveY)
+		if x != tt.wantX || y != tt.wantY || violated != tt.violated || (err != nil) != tt.wantErr {
+			t.Errorf("%s: apply() = (%g, %g, %q, %v), want (%g, %g, %q, error %v)",
+				tt.name, x, y, violated, err, tt.wantX, tt.wantY, tt.violated, tt.wantErr)
+		}
+	}
+}
+
+func TestParseZone(t *testing.T) {
+	z, err := ParseZone("door:120:150:0:180")
+	if err != nil {
+		t.Fatalf("ParseZone() error = %v", err)
+	}
+	if want := (Zone{Name: "door", MinX: 120, MaxX: 150, MinY: 0, MaxY: 180}); z != want {
+		t.Errorf("ParseZone() = %+v, want %+v", z, want)
+	}
+	if z, err := ParseZone("lamp:10.5:20:0:12.25"); err != nil || z.MinX != 10.5 || z.MaxY != 12.25 {
+		t.Errorf("ParseZone() = %+v, %v, want fractional angles", z, err)
+	}
+	for _, s := range []string{"", "door", "door:1:2:3", ":1:2:3:4", "door:150:120:0:10", "door:0:200:0:10", "door:-1:20:0:10", "door:NaN:20:0:10"} {
+		if _, err := ParseZone(s); err == nil {
+			t.Errorf("ParseZone(%q) error = nil", s)
+		}
+	}
+}
+
+func TestTurretLimits(t *testing.T) {
+	tu, err := NewWithPins(&recordingPin{}, &recordingPin{}, Config{
+		Geometry: testGeometry,
+		Limits: Limits{
+			MinY:  10,
+			Zones: []Zone{{Name: "door", MinX: 60, MaxX: 80, MinY: 0, MaxY: 180}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWithPins() error = %v", err)
+	}
+	// the turret starts at the allowed position closest to (0, 0).
+	if x, y := tu.Position(); x != 0 || y != 10 {
+		t.Errorf("Position() = (%g, %g), want (0, 10)", x, y)
+	}
+
+	// aiming at (250, 250) means (73, 20) which is inside the door.
+	tu.HandleMotion(event.Detection{Rect: image.Rect(200, 200, 300, 300)})
+	if x, y := tu.Position(); x != 81 || y != 20 {
+		t.Errorf("Position() = (%g, %g), want (81, 20)", x, y)
+	}
+	select {
+	case err := <-tu.Errors():
+		t.Errorf("HandleMotion() reported %v, violations are not errors", err)
+	default:
+	}
+
+	if err := tu.MoveY(0); err != nil {
+		t.Fatalf("MoveY() error = %v", err)
+	}
+	if _, y := tu.Position(); y != 10 {
+		t.Errorf("MoveY(0) moved to %g, want 10", y)
+	}
+
+	if err := tu.SetLimits(Limits{Zones: []Zone{{Name: "all", MinX: 0, MaxX: 180, MinY: 0, MaxY: 180}}}); err == nil {
+		t.Error("SetLimits() with nowhere to aim error = nil")
+	}
+}
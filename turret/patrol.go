@@ -0,0 +1,234 @@
+package turret
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/pkg/errors"
+)
+
+// Pattern is a sequence of positions the turret goes through while
+// it is idle.
+type Pattern interface {
+	// Start is called every time the turret becomes idle with the
+	// angles at which the target was last seen.
+	Start(x, y float64)
+	// Next returns the next position of the pattern.
+	Next() (x, y float64)
+}
+
+// Point is a position of the turret.
+type Point struct {
+	X, Y float64
+}
+
+// Home is a pattern that stays at a single position.
+type Home Point
+
+// Start implements the Pattern interface.
+func (h Home) Start(x, y float64) {}
+
+// Next implements the Pattern interface.
+func (h Home) Next() (x, y float64) { return h.X, h.Y }
+
+// Waypoints is a pattern that goes through the points in order
+// and starts over. It resumes where it was interrupted.
+type Waypoints struct {
+	Points []Point
+	i      int
+}
+
+// Start implements the Pattern interface.
+func (w *Waypoints) Start(x, y float64) {}
+
+// Next implements the Pattern interface.
+func (w *Waypoints) Next() (x, y float64) {
+	p := w.Points[w.i%len(w.Points)]
+	w.i = (w.i + 1) % len(w.Points)
+	return p.X, p.Y
+}
+
+// Raster returns waypoints that sweep the limits row by row,
+// alternating the direction of each row. step is the distance
+// in degrees between two points.
+func Raster(l Limits, step float64) *Waypoints {
+	l = l.withDefaults()
+	if step <= 0 {
+		step = 1
+	}
+	var (
+		points  []Point
+		reverse bool
+	)
+	rows, cols := int((l.MaxY-l.MinY)/step), int((l.MaxX-l.MinX)/step)
+	for i := 0; i <= rows; i++ {
+		row := make([]Point, 0, cols+1)
+		for j := 0; j <= cols; j++ {
+			row = append(row, Point{X: l.MinX + float64(j)*step, Y: l.MinY + float64(i)*step})
+		}
+		if reverse {
+			for i, j := 0, len(row)-1; i < j; i, j = i+1, j-1 {
+				row[i], row[j] = row[j], row[i]
+			}
+		}
+		reverse = !reverse
+		points = append(points, row...)
+	}
+	return &Waypoints{Points: points}
+}
+
+// Spiral is a pattern that searches around the position where the
+// target was last seen. The radius grows by Step degrees on each
+// turn up to Radius and then the spiral starts over.
+type Spiral struct {
+	Step   float64
+	Radius float64
+
+	cx, cy float64
+	n      int
+}
+
+// spiralPoints is the number of points in each turn of the spiral.
+const spiralPoints = 12
+
+// Start implements the Pattern interface.
+func (s *Spiral) Start(x, y float64) {
+	s.cx, s.cy, s.n = x, y, 0
+}
+
+// Next implements the Pattern interface.
+func (s *Spiral) Next() (x, y float64) {
+	if s.Step*float64(s.n)/spiralPoints > s.Radius {
+		s.n = 0
+	}
+	r := s.Step * float64(s.n) / spiralPoints
+	theta := 2 * math.Pi * float64(s.n) / spiralPoints
+	s.n++
+	return clampAngle(s.cx + r*math.Cos(theta)), clampAngle(s.cy + r*math.Sin(theta))
+}
+
+// clampAngle clamps a to the range of the servos.
+func clampAngle(a float64) float64 {
+	return clamp(a, 0, 180)
+}
+
+// ParsePoints parses a list of waypoints as x:y,x:y,...
+func ParsePoints(s string) ([]Point, error) {
+	var points []Point
+	for _, p := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(p), ":")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid waypoint %q, want x:y", p)
+		}
+		x, err := parseAngle(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid waypoint %q", p)
+		}
+		y, err := parseAngle(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid waypoint %q", p)
+		}
+		points = append(points, Point{X: x, Y: y})
+	}
+	return points, nil
+}
+
+// PatrolConfig configures a patrol.
+type PatrolConfig struct {
+	// Idle is the time without detections after which the turret
+	// starts the pattern.
+	Idle time.Duration
+	// Interval is the time between two positions of the pattern.
+	Interval time.Duration
+	// Pattern is what the turret does while it is idle.
+	Pattern Pattern
+}
+
+// Patrol moves a turret through a pattern while there is nothing
+// to aim at. Detections pre-empt the pattern immediately and it
+// is resumed once the turret is idle again.
+type Patrol struct {
+	turret *Turret
+	cfg    PatrolConfig
+
+	// mu is held while the patrol moves the turret, so a detection
+	// never gets overridden by a move of the pattern.
+	mu       sync.Mutex
+	lastSeen time.Time
+	idle     bool
+}
+
+// NewPatrol creates a patrol for the turret.
+func NewPatrol(t *Turret, cfg PatrolConfig) *Patrol {
+	return &Patrol{turret: t, cfg: cfg, lastSeen: time.Now()}
+}
+
+// HandleMotion implements the detector.HandleMotion function. It
+// stops the pattern and lets the turret aim at the detection.
+func (p *Patrol) HandleMotion(d event.Detection) {
+	p.Aim(d)
+}
+
+// Aim stops the pattern like HandleMotion and returns the result
+// of the turret aiming at the detection.
+func (p *Patrol) Aim(d event.Detection) Aim {
+	p.mu.Lock()
+	p.lastSeen = time.Now()
+	if p.idle {
+		p.idle = false
+		p.turret.log.Debug("Patrol pre-empted by a detection")
+	}
+	p.mu.Unlock()
+	return p.turret.Aim(d)
+}
+
+// Idle returns whether the turret is patrolling.
+func (p *Patrol) Idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idle
+}
+
+// Run moves the turret through the pattern while it is idle until
+// the context is closed.
+func (p *Patrol) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.step()
+		}
+	}
+}
+
+// step moves the turret to the next position of the pattern if
+// the turret is idle.
+func (p *Patrol) step() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.lastSeen) < p.cfg.Idle {
+		return
+	}
+	if !p.idle {
+		p.idle = true
+		x, y := p.turret.Position()
+		p.turret.log.Info("Turret is idle, starting patrol", "x", x, "y", y)
+		p.cfg.Pattern.Start(x, y)
+	}
+	x, y := p.cfg.Pattern.Next()
+	if cx, cy := p.turret.Position(); cx == x && cy == y {
+		return
+	}
+	if err := p.turret.MoveTo(x, y); err != nil {
+		if _, ok := err.(*LimitError); !ok {
+			p.turret.report(err)
+		}
+	}
+}
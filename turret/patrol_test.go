@@ -0,0 +1,102 @@
+package turret
+
+import (
+	"image"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+func TestRaster(t *testing.T) {
+	w := Raster(Limits{MinX: 10, MaxX: 30, MinY: 0, MaxY: 10}, 10)
+	want := []Point{{10, 0}, {20, 0}, {30, 0}, {30, 10}, {20, 10}, {10, 10}}
+	if !reflect.DeepEqual(w.Points, want) {
+		t.Fatalf("Raster() = %v, want %v", w.Points, want)
+	}
+	for i := 0; i < len(want)+1; i++ {
+		x, y := w.Next()
+		if p := want[i%len(want)]; x != p.X || y != p.Y {
+			t.Errorf("Next() #%d = (%g, %g), want %v", i, x, y, p)
+		}
+	}
+}
+
+func TestSpiral(t *testing.T) {
+	s := &Spiral{Step: 12, Radius: 12}
+	s.Start(90, 90)
+	if x, y := s.Next(); x != 90 || y != 90 {
+		t.Fatalf("first point = (%g, %g), want the center", x, y)
+	}
+	// after a full turn the radius is Step.
+	var x, y float64
+	for i := 0; i < 12; i++ {
+		x, y = s.Next()
+	}
+	if x != 102 || y != 90 {
+		t.Errorf("point after a turn = (%g, %g), want (102, 90)", x, y)
+	}
+	// past the radius it starts over.
+	if x, y := s.Next(); x != 90 || y != 90 {
+		t.Errorf("point past the radius = (%g, %g), want the center", x, y)
+	}
+}
+
+func TestParsePoints(t *testing.T) {
+	points, err := ParsePoints("10:20, 90:0")
+	if err != nil {
+		t.Fatalf("ParsePoints() error = %v", err)
+	}
+	if want := []Point{{10, 20}, {90, 0}}; !reflect.DeepEqual(points, want) {
+		t.Errorf("ParsePoints() = %v, want %v", points, want)
+	}
+	for _, s := range []string{"10", "10:200", "a:b"} {
+		if _, err := ParsePoints(s); err == nil {
+			t.Errorf("ParsePoints(%q) did not fail", s)
+		}
+	}
+}
+
+func TestPatrol(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	p := NewPatrol(tu, PatrolConfig{
+		Idle:    time.Hour,
+		Pattern: &Waypoints{Points: []Point{{100, 50}, {120, 60}}},
+	})
+
+	// a detection was seen recently, the turret is not idle.
+	p.step()
+	if x, y := tu.Position(); x != 0 || y != 0 {
+		t.Fatalf("Position() = (%g, %g) before the idle timeout", x, y)
+	}
+
+	p.lastSeen = time.Now().Add(-2 * time.Hour)
+	p.step()
+	if x, y := tu.Position(); x != 100 || y != 50 {
+		t.Fatalf("Position() = (%g, %g), want the first waypoint", x, y)
+	}
+
+	// a detection pre-empts the patrol and the turret aims at it.
+	rect := image.Rect(200, 200, 300, 300)
+	p.HandleMotion(event.Detection{Rect: rect})
+	if x, y := tu.Position(); x != 73 || y != 20 {
+		t.Fatalf("Position() = (%g, %g), want (73, 20)", x, y)
+	}
+	p.step()
+	if x, y := tu.Position(); x != 73 || y != 20 {
+		t.Fatalf("patrol moved the turret right after a detection")
+	}
+
+	// once idle again the patrol resumes with the next waypoint
+	// and a detection at the same place aims at it again.
+	p.lastSeen = time.Now().Add(-2 * time.Hour)
+	p.step()
+	if x, y := tu.Position(); x != 120 || y != 60 {
+		t.Fatalf("Position() = (%g, %g), want the second waypoint", x, y)
+	}
+	p.HandleMotion(event.Detection{Rect: rect})
+	if x, y := tu.Position(); x != 73 || y != 20 {
+		t.Fatalf("Position() = (%g, %g) after a detection at the same place, want (73, 20)", x, y)
+	}
+}
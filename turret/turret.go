@@ -2,53 +2,103 @@ package turret
 
 import (
 	"image"
-	"log"
+	"log/slog"
 	"math"
+	"sync"
 	"time"
 
-	"github.com/pkg/errors"
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/geometry"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
 	"github.com/matipan/gobot/platforms/raspi"
 	"github.com/matipan/gobot/sysfs"
+	"github.com/pkg/errors"
 )
 
 const (
 	dcMax = 2350000
 	dcMin = 450000
-
-	grids = 7
-
-	distance float64 = 1.35
-
-	minX = 40
-	minY = 13
-)
-
-var (
-	lastRun                  uint64
-	lastX, lastY, midX, midY int
-	x, y                     uint8
 )
 
 // Turret is the aiming turret that handles incoming
 // motion objects and moves the two servos accordingly.
 type Turret struct {
-	imgSize   int
-	distance  float64
-	sleepTime uint64
+	name string
+	geo  geometry.Geometry
 
 	x       sysfs.PWMPinner
 	y       sysfs.PWMPinner
 	adaptor *raspi.Adaptor
+
+	// mu serializes the commands sent to the servos and guards
+	// the state below, so a turret can be used from several
+	// goroutines.
+	mu sync.Mutex
+	// lastX and lastY are the pixel the turret last aimed at.
+	lastX, lastY int
+	// aimed is the result of aiming at lastX, lastY.
+	aimed Aim
+	// angleX and angleY are the angles in degrees the servos were
+	// last commanded to.
+	angleX, angleY float64
+	// lastMove is when the servos were last commanded to a
+	// new angle.
+	lastMove time.Time
+	// stateX and stateY tell whether each servo ignores the commands
+	// it is sent.
+	stateX, stateY servoState
+	limits         Limits
+	// corrX and corrY are the corrections in degrees learned from
+	// the laser dot that are added to the angles of each servo.
+	corrX, corrY float64
+	aimGain      float64
+	lead         time.Duration
+	// compensate is true if the latency is added to the lead and
+	// actuation is the moving average of the time from the decision
+	// to the servos being commanded.
+	compensate bool
+	actuation  time.Duration
+
+	errs chan error
+	// failures is the number of servo commands that failed in a row.
+	failures int
+
+	log   *slog.Logger
+	debug *slog.Logger
+	warn  *slog.Logger
+}
+
+// errBuffer is the number of errors that are buffered in the
+// error channel before new ones get dropped.
+const errBuffer = 16
+
+// Config configures a turret.
+type Config struct {
+	// Name identifies the turret in the metrics, it defaults to 0.
+	Name string
+	// Geometry converts the pixels of the processed image into
+	// the angles of the servos.
+	Geometry geometry.Geometry
+	// Limits are the mechanical safety limits of the turret.
+	Limits Limits
+	// Lead is how far ahead in time the turret aims at moving
+	// targets, using the velocity of the detections.
+	Lead time.Duration
+	// CompensateLatency adds the age of each frame and the time
+	// it takes to command the servos to the lead.
+	CompensateLatency bool
+	// AimGain is the fraction of the error between the laser dot
+	// and the target that is corrected on each detection. Zero
+	// disables the correction.
+	AimGain float64
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
 }
 
 // New creates a new turret. The pin for each servo is defined by pinX
-// and pinY. Distance will be used to make the calculations of the angles
-// that would need to be specified. imgSize is the size of the image being
-/// processed.
-// SleepTime is the amount of time the turret will wait between one movement
-// and another one. Note that if this is too low then you might cause some
-// damage to the servos.
-func New(pinX, pinY string, distance float64, imgSize int, sleepTime uint64) (*Turret, error) {
+// and pinY.
+func New(pinX, pinY string, cfg Config) (*Turret, error) {
 	r := raspi.NewAdaptor()
 	r.PiBlasterPeriod = 20000000
 	sx, err := r.PWMPin(pinX)
@@ -63,32 +113,341 @@ func New(pinX, pinY string, distance float64, imgSize int, sleepTime uint64) (*T
 	if err = r.Connect(); err != nil {
 		return nil, errors.Wrap(err, "Could not connect to raspi adaptor")
 	}
+	t, err := NewWithPins(sx, sy, cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.adaptor = r
+	return t, nil
+}
 
-	t := &Turret{x: sx, y: sy, distance: distance, imgSize: imgSize}
-	t.MoveX(0)
-	t.MoveY(0)
+// NewWithPins creates a new turret that moves the servos through
+// the given PWM pins. It allows using servos that are not connected
+// to the raspberry's pi-blaster, such as simulated ones. The turret
+// starts aiming at the allowed position closest to (0, 0).
+func NewWithPins(sx, sy sysfs.PWMPinner, cfg Config) (*Turret, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	if err := cfg.Geometry.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid geometry")
+	}
+	limits := cfg.Limits.withDefaults()
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+	name := cfg.Name
+	if name == "" {
+		name = "0"
+	}
+	t := &Turret{
+		name:       name,
+		x:          sx,
+		y:          sy,
+		geo:        cfg.Geometry,
+		limits:     limits,
+		aimGain:    cfg.AimGain,
+		lead:       cfg.Lead,
+		compensate: cfg.CompensateLatency,
+		errs:       make(chan error, errBuffer),
+		log:        logger,
+		debug:      logging.RateLimited(logger, time.Second),
+		warn:       logging.Throttled(logger, time.Second),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the servos' real position is unknown, so both are moved
+	// without taking the other axis into account.
+	x, y, _, err := t.limits.apply(0, 0, true, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.move(t.x, "x", x); err != nil {
+		return nil, err
+	}
+	if err := t.move(t.y, "y", y); err != nil {
+		return nil, err
+	}
 	return t, nil
 }
 
-// calcDutyCycle calculates the duty cycle according
-// to the specified angle.
-func calcDutyCycle(base uint8) uint32 {
-	if base > 180 {
-		base = 180
+// DutyCycle calculates the duty cycle in nanoseconds of a 20ms
+// period that moves a servo to the specified angle.
+func DutyCycle(angle float64) uint32 {
+	angle = clampAngle(angle)
+	return uint32(math.Round(angle/180*(dcMax-dcMin))) + dcMin
+}
+
+// AngleFromDutyCycle is the inverse of the duty cycle calculation,
+// it returns the angle a servo moves to for the duty cycle.
+func AngleFromDutyCycle(dc uint32) float64 {
+	return float64(int64(dc)-dcMin) * 180 / (dcMax - dcMin)
+}
+
+// MoveX moves the servo in the X axis. If the position violates
+// the limits the angle is clamped or the move is rejected.
+func (t *Turret) MoveX(angle float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	x, _, _, err := t.check(angle, t.angleY, true, false)
+	if err != nil {
+		return err
+	}
+	t.forgetTarget()
+	return t.move(t.x, "x", x)
+}
+
+// MoveY moves the servo in the Y axis. If the position violates
+// the limits the angle is clamped or the move is rejected.
+func (t *Turret) MoveY(angle float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, y, _, err := t.check(t.angleX, angle, false, true)
+	if err != nil {
+		return err
+	}
+	t.forgetTarget()
+	return t.move(t.y, "y", y)
+}
+
+// MoveTo moves both servos. If the position violates the limits
+// the angles are clamped or the move is rejected.
+func (t *Turret) MoveTo(x, y float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forgetTarget()
+	_, err := t.moveTo(x, y)
+	return err
+}
+
+// forgetTarget forgets the pixel the turret last aimed at, so
+// the next detection moves the turret even if the target did not
+// move. It must be called with mu held.
+func (t *Turret) forgetTarget() {
+	t.lastX, t.lastY = -1, -1
+}
+
+// moveTo moves both servos, first Y and then X, and returns the
+// limit the position violated, if any. It must be called with mu held.
+func (t *Turret) moveTo(x, y float64) (string, error) {
+	x, y, violated, err := t.check(x, y, true, true)
+	if err != nil {
+		return violated, err
+	}
+	if err := t.move(t.y, "y", y); err != nil {
+		return violated, err
 	}
-	dc := uint32((float32(base)/180.0)*(dcMax-dcMin)) + dcMin
-	log.Printf("Duty cycle: %v", dc)
-	return dc
+	return violated, t.move(t.x, "x", x)
 }
 
-// MoveX moves the servo in the X axis.
-func (t *Turret) MoveX(angle uint8) {
-	t.x.SetDutyCycle(calcDutyCycle(angle))
+// servoState tells whether a servo ignores its commands, for a
+// while or until it is enabled again.
+type servoState struct {
+	held     time.Time
+	disabled bool
 }
 
-// MoveY moves the servo in the Y axis.
-func (t *Turret) MoveY(angle uint8) {
-	t.y.SetDutyCycle(calcDutyCycle(angle))
+// ignores returns whether the servo ignores the commands sent at now.
+func (s servoState) ignores(now time.Time) bool {
+	return s.disabled || now.Before(s.held)
+}
+
+// state returns the state of the servo of the axis, which is x or y.
+// It must be called with mu held.
+func (t *Turret) state(axis string) (*servoState, sysfs.PWMPinner, error) {
+	switch axis {
+	case "x":
+		return &t.stateX, t.x, nil
+	case "y":
+		return &t.stateY, t.y, nil
+	}
+	return nil, nil, errors.Errorf("unknown axis %q, want x or y", axis)
+}
+
+// Hold makes the servo of the axis ignore its commands for d, it
+// keeps the angle it is at meanwhile.
+func (t *Turret) Hold(axis string, d time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, _, err := t.state(axis)
+	if err != nil {
+		return err
+	}
+	s.held = time.Now().Add(d)
+	t.log.Info("Servo held", "axis", axis, "duration", d)
+	return nil
+}
+
+// Disable makes the servo of the axis ignore its commands until
+// Enable is called. The pin of the servo is released so it no
+// longer holds its position.
+func (t *Turret) Disable(axis string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, pin, err := t.state(axis)
+	if err != nil {
+		return err
+	}
+	s.disabled = true
+	metrics.ServoDisabled.With(t.name, axis).Set(1)
+	t.log.Warn("Servo disabled", "axis", axis)
+	if err := pin.Unexport(); err != nil {
+		metrics.Errors.With("servo").Inc()
+		return errors.Wrapf(err, "Could not release servo %s", axis)
+	}
+	return nil
+}
+
+// Enable makes the servo of the axis follow its commands again and
+// moves it back to the angle it was last commanded to.
+func (t *Turret) Enable(axis string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, pin, err := t.state(axis)
+	if err != nil {
+		return err
+	}
+	*s = servoState{}
+	metrics.ServoDisabled.With(t.name, axis).Set(0)
+	t.log.Info("Servo enabled", "axis", axis)
+	angle := t.angleX
+	if axis == "y" {
+		angle = t.angleY
+	}
+	return t.move(pin, axis, angle)
+}
+
+// Enabled returns whether the servo of the axis follows its commands,
+// that is, it is neither held nor disabled.
+func (t *Turret) Enabled(axis string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, _, err := t.state(axis)
+	return err == nil && !s.ignores(time.Now())
+}
+
+// Name returns the name of the turret in the metrics.
+func (t *Turret) Name() string {
+	return t.name
+}
+
+// Position returns the angles the servos were last commanded to.
+func (t *Turret) Position() (x, y float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.angleX, t.angleY
+}
+
+// Failures returns the number of servo commands that failed in a
+// row, it is reset by every command that succeeds.
+func (t *Turret) Failures() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failures
+}
+
+// SetLimits replaces the limits of the turret. If the current
+// position violates the new limits the turret is moved to the
+// closest allowed position.
+func (t *Turret) SetLimits(l Limits) error {
+	l = l.withDefaults()
+	if err := l.validate(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the turret is moved out of a violation even if the
+	// limits reject commands.
+	clamp := l
+	clamp.Reject = false
+	x, y, violated, err := clamp.apply(t.angleX, t.angleY, true, true)
+	if err != nil {
+		return err
+	}
+	t.limits = l
+	if violated == "" {
+		return nil
+	}
+	if err := t.move(t.y, "y", y); err != nil {
+		return err
+	}
+	return t.move(t.x, "x", x)
+}
+
+// LastMove returns the time at which the servos were last
+// commanded to a new angle.
+func (t *Turret) LastMove() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastMove
+}
+
+// Limits returns the limits of the turret.
+func (t *Turret) Limits() Limits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limits
+}
+
+// check applies the limits to the position, moving only the axes
+// in moveX and moveY. Violations are logged and counted.
+func (t *Turret) check(x, y float64, moveX, moveY bool) (nx, ny float64, violated string, err error) {
+	nx, ny, violated, err = t.limits.apply(x, y, moveX, moveY)
+	if violated != "" {
+		metrics.LimitViolations.With(t.name, violated).Inc()
+		t.warn.Warn("Command violates the limits", "limit", violated, "x", x, "y", y, "clamped_x", nx, "clamped_y", ny, "rejected", err != nil)
+	}
+	return nx, ny, violated, err
+}
+
+// Errors returns the channel where the errors that happen while
+// handling motion are reported. If nobody reads from it errors
+// are dropped once the channel is full.
+func (t *Turret) Errors() <-chan error {
+	return t.errs
+}
+
+// move sets the duty cycle of the servo's pin and records
+// the command in the metrics. Servos that are held or disabled
+// ignore it. It must be called with mu held.
+func (t *Turret) move(pin sysfs.PWMPinner, axis string, angle float64) error {
+	if s, _, _ := t.state(axis); s.ignores(time.Now()) {
+		t.debug.Debug("Servo ignores the command", "axis", axis, "angle", angle, "disabled", s.disabled)
+		return nil
+	}
+	dc := DutyCycle(angle)
+	if err := pin.SetDutyCycle(dc); err != nil {
+		metrics.Errors.With("servo").Inc()
+		t.failures++
+		return errors.Wrapf(err, "Could not move servo %s to %.1f degrees", axis, angle)
+	}
+	if axis == "x" {
+		if angle != t.angleX {
+			t.lastMove = time.Now()
+		}
+		t.angleX = angle
+	} else {
+		if angle != t.angleY {
+			t.lastMove = time.Now()
+		}
+		t.angleY = angle
+	}
+	t.failures = 0
+	t.debug.Debug("Servo moved", "axis", axis, "angle", angle, "duty_cycle", dc)
+	metrics.ServoAngle.With(t.name, axis).Set(angle)
+	metrics.ServoCommands.With(t.name, axis).Inc()
+	metrics.ServoCommandRate.Mark()
+	return nil
+}
+
+// report sends err to the error channel without blocking.
+func (t *Turret) report(err error) {
+	select {
+	case t.errs <- err:
+	default:
+		t.log.Error("Error channel is full, dropping error", "err", err)
+	}
 }
 
 // HandleMotion implements the detector.HandleMotion function.
@@ -96,37 +455,207 @@ func (t *Turret) MoveY(angle uint8) {
 // detects motion it will call this function, this will translate
 // the detected rectangle into the angles we need in order to move
 // both servos to the correct position.
-func (t *Turret) HandleMotion(rect image.Rectangle) {
-	now := uint64(time.Now().Unix() * 1000)
-	//if (now - lastRun) <= t.sleepTime {
-	//	return
-	//}
-	midX, midY = rectMiddle(rect)
-	if lastX == midX && lastY == midY {
+func (t *Turret) HandleMotion(d event.Detection) {
+	t.Aim(d)
+}
+
+// Aim is the result of aiming at a detection.
+type Aim struct {
+	// Moved is true if the servos were commanded, false if the
+	// limits rejected the position or a servo failed.
+	Moved bool
+	// Limit is the limit that clamped the position away from the
+	// target, empty if it was not clamped.
+	Limit string
+	// Enabled is true if both servos follow their commands, that
+	// is, none of them is held or disabled.
+	Enabled bool
+}
+
+// OnTarget returns whether the turret points at the target.
+func (a Aim) OnTarget() bool {
+	return a.Moved && a.Limit == "" && a.Enabled
+}
+
+// Aim aims at the detection like HandleMotion and returns whether
+// the turret reached it.
+func (t *Turret) Aim(d event.Detection) Aim {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	midX, midY := rectMiddle(d.Rect)
+	if ahead := t.horizon(d); ahead > 0 {
+		// aim where the target will be.
+		midX += int(math.Round(d.Velocity.X * ahead.Seconds()))
+		midY += int(math.Round(d.Velocity.Y * ahead.Seconds()))
+	}
+	if t.lastX == midX && t.lastY == midY && !d.DotFound {
+		return t.aim()
+	}
+	t.lastX, t.lastY = midX, midY
+	geo := t.geo
+	if d.Range > 0 {
+		// the measured distance replaces the fixed one in the
+		// parallax correction.
+		geo.Distance = d.Range
+	}
+	if d.DotFound {
+		t.correct(geo, d, image.Pt(midX, midY))
+	}
+	a := angles(geo, d, image.Pt(midX, midY))
+	x, y := clampAngle(a.X+t.corrX), clampAngle(a.Y+t.corrY)
+	t.debug.Debug("Aiming at motion", "seq", d.Frame.Seq, "pixel_x", midX, "pixel_y", midY, "distance", geo.Distance, "angle_x", a.X, "angle_y", a.Y, "servo_x", x, "servo_y", y)
+	violated, err := t.moveTo(x, y)
+	t.aimed = Aim{Moved: err == nil, Limit: violated}
+	if err != nil {
+		// violations of the limits are already logged and counted,
+		// they are not failures of the turret.
+		if _, ok := err.(*LimitError); !ok {
+			t.report(err)
+		}
+		return t.aim()
+	}
+	t.measureLatency(d)
+	return t.aim()
+}
+
+// aim returns the result of aiming at the last target with whether
+// the servos follow their commands now. It must be called with mu held.
+func (t *Turret) aim() Aim {
+	a, now := t.aimed, time.Now()
+	a.Enabled = !t.stateX.ignores(now) && !t.stateY.ignores(now)
+	return a
+}
+
+// actuationWeight is the weight of each new measure in the moving
+// average of the time from the decision to the servos being commanded.
+const actuationWeight = 0.1
+
+// horizon returns how far ahead in time the turret aims at the
+// target: the lead plus, if the latency is compensated, the age
+// of the frame and the time the turret takes to command the
+// servos. It must be called with mu held.
+func (t *Turret) horizon(d event.Detection) time.Duration {
+	ahead := t.lead
+	if t.compensate && !d.Frame.CapturedAt.IsZero() {
+		ahead += time.Since(d.Frame.CapturedAt) + t.actuation
+	}
+	return ahead
+}
+
+// measureLatency records the latency from the capture of the frame
+// and from the decision to the servos being commanded. It must be
+// called with mu held.
+func (t *Turret) measureLatency(d event.Detection) {
+	now := time.Now()
+	if !d.Frame.CapturedAt.IsZero() {
+		metrics.CaptureToActuation.Observe(now.Sub(d.Frame.CapturedAt).Seconds())
+	}
+	if d.DecidedAt.IsZero() {
+		return
+	}
+	lat := now.Sub(d.DecidedAt)
+	metrics.DecisionToActuation.Observe(lat.Seconds())
+	if t.actuation == 0 {
+		t.actuation = lat
+	} else {
+		t.actuation += time.Duration(actuationWeight * float64(lat-t.actuation))
+	}
+}
+
+// Latency returns the moving average of the time from the decision
+// to the servos being commanded.
+func (t *Turret) Latency() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.actuation
+}
+
+// maxCorrection is the maximum correction in degrees learned
+// from the laser dot.
+const maxCorrection = 20
+
+// correct updates the correction of the aim with the error between
+// the target and the laser dot of the detection. The dot is where the
+// turret actually aims, so the difference between the angles of the
+// target and the angles of the dot is what is missing. It must be
+// called with mu held.
+func (t *Turret) correct(geo geometry.Geometry, d event.Detection, target image.Point) {
+	dot := d.Dot
+	metrics.AimError.With(t.name, "x").Set(float64(target.X - dot.X))
+	metrics.AimError.With(t.name, "y").Set(float64(target.Y - dot.Y))
+	if t.aimGain == 0 {
 		return
 	}
-	lastRun = now
-	lastX, lastY = midX, midY
-	x := angleFromPixel(midX, t.imgSize, t.distance)
-	if (x + minX) < 180 {
-		x = x + minX
+	ta, da := angles(geo, d, target), angles(geo, d, dot)
+	t.corrX = clampCorrection(t.corrX + t.aimGain*(ta.X-da.X))
+	t.corrY = clampCorrection(t.corrY + t.aimGain*(ta.Y-da.Y))
+	metrics.AimCorrection.With(t.name, "x").Set(t.corrX)
+	metrics.AimCorrection.With(t.name, "y").Set(t.corrY)
+	t.debug.Debug("Aim corrected", "target", target.String(), "dot", dot.String(), "correction_x", t.corrX, "correction_y", t.corrY)
+}
+
+// Correction returns the correction in degrees learned from the
+// laser dot for each servo.
+func (t *Turret) Correction() (x, y float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.corrX, t.corrY
+}
+
+func clampCorrection(c float64) float64 {
+	return math.Max(-maxCorrection, math.Min(maxCorrection, c))
+}
+
+// angles returns the angles of both servos needed to aim at the
+// pixel p of the image where d was detected, taken by a fixed camera
+// or by one on the turret.
+func angles(geo geometry.Geometry, d event.Detection, p image.Point) event.Angles {
+	if d.OnTurret {
+		return geo.AnglesFrom(float64(p.X), float64(p.Y), d.Camera)
 	}
-	y := angleFromPixel(t.imgSize-midY, t.imgSize, t.distance)
-	if y >= minY {
-		y = y - minY
+	return geo.Angles(float64(p.X), float64(p.Y))
+}
+
+// Angles returns the angles of both servos needed to aim at
+// the pixel p of the processed image taken by a fixed camera.
+func (t *Turret) Angles(p image.Point) (x, y float64) {
+	a := t.geo.Angles(float64(p.X), float64(p.Y))
+	return a.X, a.Y
+}
+
+// Pixel returns the pixel of the processed image the turret aims
+// at with the given angles. It is the inverse of Angles, so it can
+// fall outside of the image.
+func (t *Turret) Pixel(x, y float64) image.Point {
+	px, py, _ := t.geo.Pixel(event.Angles{X: x, Y: y})
+	return image.Pt(int(math.Round(px)), int(math.Round(py)))
+}
+
+// ZoneArea is a forbidden zone projected onto the processed image.
+type ZoneArea struct {
+	Name string
+	Rect image.Rectangle
+}
+
+// ZoneAreas returns the forbidden zones that are visible in the
+// processed image.
+func (t *Turret) ZoneAreas() []ZoneArea {
+	t.mu.Lock()
+	zones := t.limits.Zones
+	t.mu.Unlock()
+
+	bounds := image.Rect(0, 0, t.geo.Camera.Width, t.geo.Camera.Height)
+	var areas []ZoneArea
+	for _, z := range zones {
+		r := image.Rectangle{Min: t.Pixel(z.MinX, z.MaxY), Max: t.Pixel(z.MaxX, z.MinY)}.Canon().Intersect(bounds)
+		if !r.Empty() {
+			areas = append(areas, ZoneArea{Name: z.Name, Rect: r})
+		}
 	}
-	log.Printf("pixels(x,y)=(%v,%v) -- angles(x,y)=(%v,%v)", midX, midY, x, y)
-	t.MoveY(y)
-	t.MoveX(x)
+	return areas
 }
 
 // rectMiddle calculates the middle x and y of a rectangle.
 func rectMiddle(rect image.Rectangle) (x int, y int) {
 	return (rect.Max.X-rect.Min.X)/2 + rect.Min.X, (rect.Max.Y-rect.Min.Y)/2 + rect.Min.Y
 }
-
-// angleFromPixel calculates the angle of the given pixel
-// for the specific size and distance of the object.
-func angleFromPixel(pixel, size int, distance float64) uint8 {
-	return uint8((math.Atan((float64(pixel)*distance)/float64(size))) * 180 / math.Pi)
-}
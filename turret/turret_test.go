@@ -0,0 +1,491 @@
+package turret
+
+import (
+	"image"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/geometry"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/gobot/platforms/raspi"
+	"github.com/matipan/gobot/sysfs"
+)
+
+const piBlaster = "/dev/pi-blaster"
+
+// testGeometry is a camera with a field of view of 90 degrees in
+// a 500x500 image, whose middle is at (73, 20).
+var testGeometry = geometry.Geometry{
+	Camera: geometry.FromFOV(500, 500, 90, 90),
+	Center: event.Angles{X: 73, Y: 20},
+}
+
+// newTestTurret creates a turret whose servos write to a mocked
+// pi-blaster on the BCM pins 13 (X) and 19 (Y).
+func newTestTurret(t *testing.T) (*Turret, *sysfs.MockFilesystem) {
+	t.Helper()
+	fs := sysfs.NewMockFilesystem([]string{piBlaster})
+	sysfs.SetFilesystem(fs)
+	t.Cleanup(func() { sysfs.SetFilesystem(&sysfs.NativeFilesystem{}) })
+
+	sx, sy := raspi.NewPWMPin("13"), raspi.NewPWMPin("19")
+	sx.SetPeriod(20000000)
+	sy.SetPeriod(20000000)
+	tu, err := NewWithPins(sx, sy, Config{Geometry: testGeometry})
+	if err != nil {
+		t.Fatalf("NewWithPins() error = %v", err)
+	}
+	return tu, fs
+}
+
+// near reports whether the position (x, y) is within 0.01 degrees
+// of (wantX, wantY).
+func near(x, y, wantX, wantY float64) bool {
+	return math.Abs(x-wantX) <= 0.01 && math.Abs(y-wantY) <= 0.01
+}
+
+func TestCalcDutyCycle(t *testing.T) {
+	tests := []struct {
+		angle float64
+		want  uint32
+	}{
+		{0, dcMin},
+		{45.5, 930278},
+		{90, 1400000},
+		{180, dcMax},
+		{200, dcMax},
+		{-10, dcMin},
+	}
+	for _, tt := range tests {
+		if got := DutyCycle(tt.angle); got != tt.want {
+			t.Errorf("DutyCycle(%g) = %d, want %d", tt.angle, got, tt.want)
+		}
+	}
+}
+
+func TestAngleFromDutyCycle(t *testing.T) {
+	for _, angle := range []float64{0, 45, 90, 180} {
+		if got := AngleFromDutyCycle(DutyCycle(angle)); math.Abs(got-angle) > 0.01 {
+			t.Errorf("AngleFromDutyCycle(DutyCycle(%g)) = %v", angle, got)
+		}
+	}
+}
+
+func TestRectMiddle(t *testing.T) {
+	tests := []struct {
+		rect         image.Rectangle
+		wantX, wantY int
+	}{
+		{image.Rect(0, 0, 0, 0), 0, 0},
+		{image.Rect(10, 20, 30, 60), 20, 40},
+		{image.Rect(100, 100, 101, 103), 100, 101},
+	}
+	for _, tt := range tests {
+		if x, y := rectMiddle(tt.rect); x != tt.wantX || y != tt.wantY {
+			t.Errorf("rectMiddle(%v) = (%d, %d), want (%d, %d)", tt.rect, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestNewWithPinsMovesToZero(t *testing.T) {
+	_, fs := newTestTurret(t)
+	// both servos are moved to 0 degrees, the Y servo is the
+	// last one written.
+	if got, want := fs.Files[piBlaster].Contents, "19=0.024\n"; got != want {
+		t.Errorf("pi-blaster = %q, want %q", got, want)
+	}
+}
+
+func TestMove(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	tests := []struct {
+		move  func(float64) error
+		angle float64
+		want  string
+	}{
+		{tu.MoveX, 90, "13=0.07\n"},
+		{tu.MoveY, 90, "19=0.07\n"},
+		{tu.MoveX, 180, "13=0.1175\n"},
+		{tu.MoveY, 0, "19=0.024\n"},
+	}
+	for _, tt := range tests {
+		if err := tt.move(tt.angle); err != nil {
+			t.Fatalf("move(%g) error = %v", tt.angle, err)
+		}
+		if got := fs.Files[piBlaster].Contents; got != tt.want {
+			t.Errorf("move(%g) wrote %q, want %q", tt.angle, got, tt.want)
+		}
+	}
+}
+
+func TestMoveWithoutPiBlaster(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	delete(fs.Files, piBlaster)
+	if err := tu.MoveX(90); err == nil {
+		t.Error("MoveX() error = nil, want write error")
+	}
+}
+
+func TestMetricsByTurret(t *testing.T) {
+	ta, err := NewWithPins(&recordingPin{}, &recordingPin{}, Config{Name: "a", Geometry: testGeometry})
+	if err != nil {
+		t.Fatalf("NewWithPins() error = %v", err)
+	}
+	tb, err := NewWithPins(&recordingPin{}, &recordingPin{}, Config{Name: "b", Geometry: testGeometry})
+	if err != nil {
+		t.Fatalf("NewWithPins() error = %v", err)
+	}
+	ta.MoveX(30)
+	tb.MoveX(150)
+	// each turret exports its own angles.
+	if a, b := metrics.ServoAngle.With("a", "x").Value(), metrics.ServoAngle.With("b", "x").Value(); a != 30 || b != 150 {
+		t.Errorf("angles of the turrets = %v, %v, want 30, 150", a, b)
+	}
+	if name := ta.Name(); name != "a" {
+		t.Errorf("Name() = %q, want a", name)
+	}
+}
+
+func TestHoldAndDisable(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	if err := tu.MoveX(90); err != nil {
+		t.Fatalf("MoveX() error = %v", err)
+	}
+	if err := tu.Hold("x", time.Hour); err != nil {
+		t.Fatalf("Hold() error = %v", err)
+	}
+	if tu.Enabled("x") || !tu.Enabled("y") {
+		t.Errorf("Enabled() = (%v, %v) while x is held, want (false, true)", tu.Enabled("x"), tu.Enabled("y"))
+	}
+	// the held servo ignores the command, the other follows it.
+	if err := tu.MoveTo(120, 45); err != nil {
+		t.Fatalf("MoveTo() error = %v", err)
+	}
+	if x, y := tu.Position(); x != 90 || y != 45 {
+		t.Errorf("Position() = (%g, %g), want (90, 45)", x, y)
+	}
+
+	if err := tu.Disable("y"); err != nil {
+		t.Fatalf("Disable() error = %v", err)
+	}
+	if got, want := fs.Files[piBlaster].Contents, "release 19\n"; got != want {
+		t.Errorf("Disable() wrote %q, want %q", got, want)
+	}
+	if err := tu.MoveY(60); err != nil {
+		t.Fatalf("MoveY() error = %v", err)
+	}
+	if got, want := fs.Files[piBlaster].Contents, "release 19\n"; got != want {
+		t.Errorf("MoveY() of a disabled servo wrote %q, want %q", got, want)
+	}
+
+	// enabling the servo moves it back to its angle.
+	if err := tu.Enable("y"); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if got, want := fs.Files[piBlaster].Contents, "19=0.04625\n"; got != want {
+		t.Errorf("Enable() wrote %q, want %q", got, want)
+	}
+	if err := tu.Disable("z"); err == nil {
+		t.Error("Disable(z) error = nil")
+	}
+}
+
+func TestHandleMotion(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	file := fs.Files[piBlaster]
+
+	// the middle of the rectangle is the middle of the image, where
+	// the optical axis of the camera is.
+	rect := image.Rect(200, 200, 300, 300)
+	if x, y := tu.Angles(image.Pt(250, 250)); x != 73 || y != 20 {
+		t.Fatalf("Angles() = (%v, %v), want (73, 20)", x, y)
+	}
+
+	seq := file.Seq
+	tu.HandleMotion(event.Detection{Rect: rect})
+	// Y is moved first and then X, so two writes happen and X is
+	// the last one.
+	if got, want := file.Seq-seq, 2; got != want {
+		t.Errorf("HandleMotion() wrote %d times to pi-blaster, want %d", got, want)
+	}
+	if got, want := file.Contents, "13=0.0610278\n"; got != want {
+		t.Errorf("pi-blaster = %q, want %q", got, want)
+	}
+
+	// the same middle again does not move the servos.
+	seq = file.Seq
+	tu.HandleMotion(event.Detection{Rect: image.Rect(210, 210, 290, 290)})
+	if file.Seq != seq {
+		t.Errorf("HandleMotion() with the same middle wrote to pi-blaster")
+	}
+
+	// (50, 250) is atan(200/250), 38.66 degrees, left of the middle,
+	// the angle is not rounded before the duty cycle is computed.
+	tu.HandleMotion(event.Detection{Rect: image.Rect(0, 0, 100, 500)})
+	if got, want := file.Contents, "13=0.040624\n"; got != want {
+		t.Errorf("pi-blaster = %q, want %q", got, want)
+	}
+}
+
+func TestAim(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	d := event.Detection{Rect: image.Rect(200, 200, 300, 300)}
+	if got := tu.Aim(d); !got.OnTarget() {
+		t.Fatalf("Aim() = %+v, want on target", got)
+	}
+
+	// the middle of the image is at (73, 20), inside the zone.
+	zone := Zone{Name: "door", MinX: 70, MaxX: 80, MinY: 10, MaxY: 30}
+	if err := tu.SetLimits(Limits{Zones: []Zone{zone}}); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	tu.MoveTo(0, 0)
+	if got := tu.Aim(d); !got.Moved || got.Limit != "door" || got.OnTarget() {
+		t.Errorf("Aim() into a zone = %+v, want moved and clamped by the door", got)
+	}
+	if err := tu.SetLimits(Limits{Zones: []Zone{zone}, Reject: true}); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	tu.MoveTo(0, 0)
+	if got := tu.Aim(d); got.Moved || got.OnTarget() {
+		t.Errorf("Aim() into a rejecting zone = %+v, want not moved", got)
+	}
+	// the same target again keeps the result.
+	if got := tu.Aim(d); got.Moved || got.OnTarget() {
+		t.Errorf("Aim() at the same target = %+v, want not moved", got)
+	}
+
+	if err := tu.SetLimits(Limits{}); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+	tu.MoveTo(0, 0)
+	if err := tu.Hold("y", time.Hour); err != nil {
+		t.Fatalf("Hold() error = %v", err)
+	}
+	if got := tu.Aim(d); !got.Moved || got.Enabled || got.OnTarget() {
+		t.Errorf("Aim() with a held servo = %+v, want moved but not enabled", got)
+	}
+}
+
+func TestHandleMotionCorrectsAim(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	tu.aimGain = 0.5
+
+	// the target is at 73 degrees in X but the dot at (150, 250)
+	// shows the turret aims at 51.2, half of the error is corrected.
+	rect := image.Rect(200, 200, 300, 300)
+	tu.HandleMotion(event.Detection{Rect: rect, Dot: image.Pt(150, 250), DotFound: true})
+	if x, y := tu.Position(); !near(x, y, 83.9, 20) {
+		t.Errorf("Position() = (%g, %g), want (83.9, 20)", x, y)
+	}
+	if x, y := tu.Correction(); math.Abs(x-10.9) > 0.01 || y != 0 {
+		t.Errorf("Correction() = (%v, %v), want (10.9, 0)", x, y)
+	}
+
+	// once the dot is on the target the correction is kept.
+	tu.HandleMotion(event.Detection{Rect: rect, Dot: image.Pt(250, 250), DotFound: true})
+	if x, y := tu.Position(); !near(x, y, 83.9, 20) {
+		t.Errorf("Position() = (%g, %g), want (83.9, 20)", x, y)
+	}
+}
+
+func TestHandleMotionCorrectsAimOnTurret(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	tu.aimGain = 0.5
+
+	// with the camera on the turret tilted 25 degrees above the
+	// middle, 100 pixels are more degrees of pan than with a fixed
+	// camera, so the error is measured from where the turret aimed.
+	camera := event.Angles{X: 90, Y: 45}
+	tu.HandleMotion(event.Detection{Rect: image.Rect(200, 200, 300, 300), OnTurret: true, Camera: camera, Dot: image.Pt(150, 250), DotFound: true})
+	ta, da := testGeometry.AnglesFrom(250, 250, camera), testGeometry.AnglesFrom(150, 250, camera)
+	want := 0.5 * (ta.X - da.X)
+	if x, _ := tu.Correction(); math.Abs(x-want) > 0.01 || math.Abs(x-10.9) < 0.5 {
+		t.Errorf("Correction() x = %v, want %v", x, want)
+	}
+}
+
+func TestHandleMotionLeads(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	tu.lead = 500 * time.Millisecond
+
+	// the target moves 200 pixels per second to the right, in
+	// 500ms it will be at (250, 250).
+	tu.HandleMotion(event.Detection{Rect: image.Rect(100, 200, 200, 300), Velocity: event.Velocity{X: 200}})
+	if x, y := tu.Position(); x != 73 || y != 20 {
+		t.Errorf("Position() = (%g, %g), want (73, 20)", x, y)
+	}
+}
+
+func TestHandleMotionCompensatesLatency(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	tu.compensate = true
+
+	// the frame is 100ms old and the target moves 1000 pixels per
+	// second, so it is 100 pixels ahead, at (250, 250).
+	now := time.Now()
+	tu.HandleMotion(event.Detection{
+		Frame:     event.Frame{Seq: 2, CapturedAt: now.Add(-100 * time.Millisecond)},
+		DecidedAt: now.Add(-10 * time.Millisecond),
+		Rect:      image.Rect(100, 200, 200, 300),
+		Velocity:  event.Velocity{X: 1000},
+	})
+	if x, y := tu.Position(); x != 73 || y != 20 {
+		t.Errorf("Position() = (%g, %g), want (73, 20)", x, y)
+	}
+	if lat := tu.Latency(); lat < 10*time.Millisecond {
+		t.Errorf("Latency() = %v, want at least 10ms", lat)
+	}
+}
+
+func TestHandleMotionOnTurret(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	camera := event.Angles{X: 90, Y: 45}
+
+	// the middle of the image is where the turret aimed.
+	tu.HandleMotion(event.Detection{Rect: image.Rect(200, 200, 300, 300), OnTurret: true, Camera: camera})
+	if x, y := tu.Position(); !near(x, y, 90, 45) {
+		t.Errorf("Position() = (%g, %g), want (90, 45)", x, y)
+	}
+	moved := tu.LastMove()
+	if moved.IsZero() {
+		t.Fatal("LastMove() is zero after moving")
+	}
+
+	// 100 pixels to the right and up is 21.8 degrees in each axis
+	// of the camera, which is tilted 25 degrees above the middle so
+	// the target is 28.5 degrees away in pan and 18.1 in tilt.
+	tu.HandleMotion(event.Detection{Rect: image.Rect(300, 100, 400, 200), OnTurret: true, Camera: camera})
+	if x, y := tu.Position(); !near(x, y, 118.48, 63.11) {
+		t.Errorf("Position() = (%g, %g), want (118.48, 63.11)", x, y)
+	}
+	if !tu.LastMove().After(moved) {
+		t.Error("LastMove() did not change after moving")
+	}
+}
+
+func TestHandleMotionRange(t *testing.T) {
+	tu, _ := newTestTurret(t)
+	// the camera is 50cm to the left of the pivot and the targets
+	// are assumed to be far away.
+	tu.geo.Offset = geometry.Vec{X: -0.5}
+
+	rect := image.Rect(200, 200, 300, 300)
+	tu.HandleMotion(event.Detection{Rect: rect})
+	if x, _ := tu.Position(); x != 73 {
+		t.Errorf("Position() without range x = %g, want 73", x)
+	}
+
+	// at 50cm the target in the middle of the image is 45 degrees
+	// to the left of the pivot.
+	tu.forgetTarget()
+	tu.HandleMotion(event.Detection{Rect: rect, Range: 0.5})
+	if x, _ := tu.Position(); x != 28 {
+		t.Errorf("Position() at 0.5m x = %g, want 28", x)
+	}
+}
+
+func TestHandleMotionReportsErrors(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	delete(fs.Files, piBlaster)
+	tu.HandleMotion(event.Detection{Rect: image.Rect(200, 200, 300, 300)})
+	select {
+	case err := <-tu.Errors():
+		if err == nil {
+			t.Error("Errors() received a nil error")
+		}
+	default:
+		t.Error("HandleMotion() did not report the write error")
+	}
+}
+
+func TestFailures(t *testing.T) {
+	tu, fs := newTestTurret(t)
+	f := fs.Files[piBlaster]
+	delete(fs.Files, piBlaster)
+	tu.MoveX(10)
+	tu.MoveX(20)
+	if got := tu.Failures(); got != 2 {
+		t.Fatalf("Failures() = %d, want 2", got)
+	}
+	fs.Files[piBlaster] = f
+	if err := tu.MoveX(30); err != nil {
+		t.Fatalf("MoveX() error = %v", err)
+	}
+	if got := tu.Failures(); got != 0 {
+		t.Fatalf("Failures() = %d after a successful move, want 0", got)
+	}
+}
+
+// recordingPin is a PWM pin that records the duty cycles written
+// to it. Only SetDutyCycle is implemented.
+type recordingPin struct {
+	sysfs.PWMPinner
+
+	mu     sync.Mutex
+	duties []uint32
+}
+
+func (p *recordingPin) SetDutyCycle(duty uint32) error {
+	p.mu.Lock()
+	p.duties = append(p.duties, duty)
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *recordingPin) writes() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.duties)
+}
+
+func TestConcurrentTurrets(t *testing.T) {
+	const (
+		turrets    = 2
+		goroutines = 8
+		moves      = 50
+	)
+	pins := make([][2]*recordingPin, turrets)
+	ts := make([]*Turret, turrets)
+	for i := range ts {
+		pins[i] = [2]*recordingPin{{}, {}}
+		tu, err := NewWithPins(pins[i][0], pins[i][1], Config{Geometry: testGeometry})
+		if err != nil {
+			t.Fatalf("NewWithPins() error = %v", err)
+		}
+		ts[i] = tu
+	}
+
+	// every goroutine uses every turret at the same time, as when
+	// several turrets are driven by the same detector.
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < moves; i++ {
+				for _, tu := range ts {
+					tu.HandleMotion(event.Detection{Rect: image.Rect(0, 0, 2*(g*moves+i)+2, 10)})
+					tu.MoveX(float64(i))
+					tu.MoveY(float64(i))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i, p := range pins {
+		// the initial move, one direct move per iteration and the
+		// moves of HandleMotion, which never repeats a middle.
+		want := 1 + goroutines*moves*2
+		if got := p[0].writes(); got != want {
+			t.Errorf("turret %d X pin got %d writes, want %d", i, got, want)
+		}
+		if got := p[1].writes(); got != want {
+			t.Errorf("turret %d Y pin got %d writes, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package wake keeps the camera pipeline in a low-power mode until
+// a presence sensor, such as a PIR, senses motion. The pipeline is
+// paused or throttled while asleep and runs at full rate for a while
+// after each trigger.
+package wake
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+	"github.com/matipan/dartagnan/logging"
+	"github.com/matipan/dartagnan/metrics"
+	"github.com/matipan/gobot/drivers/gpio"
+)
+
+// Sensor publishes an event when it starts and stops sensing motion.
+// gpio.PIRMotionDriver implements it.
+type Sensor interface {
+	On(name string, f func(s interface{})) error
+	Start() error
+}
+
+var _ Sensor = (*gpio.PIRMotionDriver)(nil)
+
+// Config configures the wake-up.
+type Config struct {
+	// Awake is how long the pipeline runs at full rate after the
+	// sensor stops sensing motion.
+	Awake time.Duration
+	// SleepFPS is the rate at which frames are processed while
+	// asleep. Zero pauses the pipeline.
+	SleepFPS float64
+	// Logger is where logs are written, if it is nil they are discarded.
+	Logger *slog.Logger
+}
+
+// Wake decides when the pipeline processes frames. It implements
+// the detector.Throttle and detector.Waker interfaces.
+type Wake struct {
+	cfg Config
+
+	mu sync.Mutex
+	// active is true while the sensor senses motion and until is
+	// when the pipeline falls asleep after it stops.
+	active bool
+	until  time.Time
+	// woken is closed when the pipeline wakes up.
+	woken chan struct{}
+	// sleeping is true once Wait found the pipeline asleep and last
+	// is when Wait last returned.
+	sleeping  bool
+	last      time.Time
+	listeners []func(event.Presence)
+
+	log *slog.Logger
+}
+
+// New creates a wake-up that starts asleep.
+func New(cfg Config) *Wake {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logging.Discard()
+	}
+	metrics.Awake.Set(0)
+	return &Wake{cfg: cfg, woken: make(chan struct{}), sleeping: true, log: logger}
+}
+
+// Watch wakes the pipeline up when the sensor senses motion.
+func (w *Wake) Watch(s Sensor) error {
+	if err := s.On(gpio.MotionDetected, func(interface{}) { w.Presence(true) }); err != nil {
+		return err
+	}
+	if err := s.On(gpio.MotionStopped, func(interface{}) { w.Presence(false) }); err != nil {
+		return err
+	}
+	return s.Start()
+}
+
+// OnPresence calls f with every event of the sensor. f is called
+// with no locks held.
+func (w *Wake) OnPresence(f func(event.Presence)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, f)
+}
+
+// Presence records that the sensor started or stopped sensing motion.
+func (w *Wake) Presence(active bool) {
+	now := time.Now()
+	w.mu.Lock()
+	wasAwake := w.awake(now)
+	w.active = active
+	if !active {
+		w.until = now.Add(w.cfg.Awake)
+	}
+	if !wasAwake && active {
+		close(w.woken)
+		w.woken = make(chan struct{})
+		if w.sleeping {
+			w.sleeping = false
+			metrics.Awake.Set(1)
+			w.log.Info("Presence sensed, waking up")
+		}
+	}
+	listeners := w.listeners
+	w.mu.Unlock()
+
+	if active {
+		metrics.PresenceEvents.Inc()
+	}
+	e := event.Presence{At: now, Active: active}
+	for _, f := range listeners {
+		f(e)
+	}
+}
+
+// Awake returns whether the pipeline runs at full rate.
+func (w *Wake) Awake() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.awake(time.Now())
+}
+
+// awake returns whether the pipeline runs at full rate at now. It
+// must be called with mu held.
+func (w *Wake) awake(now time.Time) bool {
+	return w.active || now.Before(w.until)
+}
+
+// Wait blocks until the next frame should be processed: at once
+// while awake, otherwise at the sleep rate or when the sensor
+// senses motion. It returns the error of the context if it is
+// closed first.
+func (w *Wake) Wait(ctx context.Context) error {
+	w.mu.Lock()
+	now := time.Now()
+	if w.awake(now) {
+		w.last = now
+		w.mu.Unlock()
+		return nil
+	}
+	if !w.sleeping {
+		w.sleeping = true
+		metrics.Awake.Set(0)
+		w.log.Info("Nothing sensed, going to sleep", "sleep_fps", w.cfg.SleepFPS)
+	}
+	woken := w.woken
+	var next <-chan time.Time
+	if w.cfg.SleepFPS > 0 {
+		wait := time.Duration(float64(time.Second)/w.cfg.SleepFPS) - now.Sub(w.last)
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		next = timer.C
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-woken:
+	case <-next:
+	}
+	w.mu.Lock()
+	w.last = time.Now()
+	w.mu.Unlock()
+	return nil
+}
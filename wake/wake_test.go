@@ -0,0 +1,74 @@
+package wake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matipan/dartagnan/event"
+)
+
+// waitFor runs w.Wait and returns how long it blocked.
+func waitFor(t *testing.T, w *Wake, timeout time.Duration) (time.Duration, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := w.Wait(ctx)
+	return time.Since(start), err
+}
+
+func TestWaitPausedUntilPresence(t *testing.T) {
+	w := New(Config{Awake: time.Hour})
+	if w.Awake() {
+		t.Fatal("Awake() = true before the sensor fired")
+	}
+	if _, err := waitFor(t, w, 20*time.Millisecond); err == nil {
+		t.Fatal("Wait() returned while paused")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		w.Presence(true)
+	}()
+	if _, err := waitFor(t, w, time.Second); err != nil {
+		t.Fatalf("Wait() error = %v, want woken by the sensor", err)
+	}
+
+	// the pipeline stays awake after the sensor stops.
+	w.Presence(false)
+	if !w.Awake() {
+		t.Error("Awake() = false right after the sensor stopped")
+	}
+	if d, err := waitFor(t, w, time.Second); err != nil || d > 10*time.Millisecond {
+		t.Errorf("Wait() while awake = (%v, %v), want at once", d, err)
+	}
+}
+
+func TestWaitFallsAsleep(t *testing.T) {
+	w := New(Config{Awake: 10 * time.Millisecond, SleepFPS: 20})
+	w.Presence(true)
+	w.Presence(false)
+	time.Sleep(20 * time.Millisecond)
+	if w.Awake() {
+		t.Fatal("Awake() = true after the awake period")
+	}
+
+	// asleep frames are processed at 20 FPS.
+	waitFor(t, w, time.Second)
+	d, err := waitFor(t, w, time.Second)
+	if err != nil || d < 40*time.Millisecond {
+		t.Errorf("Wait() asleep = (%v, %v), want about 50ms", d, err)
+	}
+}
+
+func TestOnPresence(t *testing.T) {
+	w := New(Config{})
+	var got []event.Presence
+	w.OnPresence(func(e event.Presence) { got = append(got, e) })
+	w.Presence(true)
+	w.Presence(false)
+	if len(got) != 2 || !got[0].Active || got[1].Active || got[0].At.IsZero() {
+		t.Errorf("OnPresence() got %+v, want active and then inactive", got)
+	}
+}
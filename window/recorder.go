@@ -0,0 +1,67 @@
+package window
+
+import (
+	"image"
+
+	"github.com/pkg/errors"
+	"gocv.io/x/gocv"
+)
+
+// Recorder records the frames to a video file. It records either
+// the raw frames or the frames with the overlays drawn on them.
+type Recorder struct {
+	w   *gocv.VideoWriter
+	raw bool
+	img gocv.Mat
+
+	size image.Point
+}
+
+// NewRecorder creates a recorder that writes a MJPEG video with
+// frames of the given size to the file name.
+func NewRecorder(name string, fps float64, size image.Point, raw bool) (*Recorder, error) {
+	w, err := gocv.VideoWriterFile(name, "MJPG", fps, size.X, size.Y, true)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open %s for recording", name)
+	}
+	return &Recorder{w: w, raw: raw, img: gocv.NewMat(), size: size}, nil
+}
+
+// StreamFrame implements the streamer interface, it records the
+// frame unless the recorder records raw frames.
+func (r *Recorder) StreamFrame(img gocv.Mat) {
+	if !r.raw {
+		r.write(img)
+	}
+}
+
+// StreamRaw implements the raw streamer interface, it records the
+// frame if the recorder records raw frames.
+func (r *Recorder) StreamRaw(img gocv.Mat) {
+	if r.raw {
+		r.write(img)
+	}
+}
+
+// StreamDelta implements the streamer interface.
+func (r *Recorder) StreamDelta(img gocv.Mat) {}
+
+// StreamThresh implements the streamer interface.
+func (r *Recorder) StreamThresh(img gocv.Mat) {}
+
+func (r *Recorder) write(img gocv.Mat) {
+	if img.Cols() != r.size.X || img.Rows() != r.size.Y {
+		gocv.Resize(img, &r.img, r.size, 0, 0, gocv.InterpolationLinear)
+		img = r.img
+	}
+	r.w.Write(img)
+}
+
+// Close closes the video file.
+func (r *Recorder) Close() error {
+	err := r.w.Close()
+	if cerr := r.img.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
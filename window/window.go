@@ -3,6 +3,7 @@ package window
 import (
 	"image"
 
+	"github.com/hashicorp/go-multierror"
 	"gocv.io/x/gocv"
 )
 
@@ -57,11 +58,17 @@ func imShow(w *gocv.Window, img gocv.Mat) {
 	w.WaitKey(1)
 }
 
-// Close closes all the windows and mat.
+// Close closes all the windows and mat. Every one of them is
+// closed even if closing another one fails.
 func (m *Manager) Close() error {
-	err := m.frames.Close()
-	err = m.deltas.Close()
-	err = m.thresholds.Close()
-	err = m.img.Close()
-	return err
+	var result *multierror.Error
+	for _, w := range []*gocv.Window{m.frames, m.deltas, m.thresholds} {
+		if err := w.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if err := m.img.Close(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
 }